@@ -0,0 +1,101 @@
+// Package oauth contains helpers shared by the auth subpackages.
+package oauth
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// NoRedirect returns a copy of c that does not follow redirects.
+// The token endpoints are never expected to redirect; following a redirect
+// (for instance, one issued by a misconfigured proxy) could send the client
+// credentials in the Basic auth header to an unintended location. With the
+// returned client, a 3xx response is returned as-is, so it is reported as a
+// *lyft.StatusError.
+func NoRedirect(c *http.Client) *http.Client {
+	if c == nil {
+		c = http.DefaultClient
+	}
+	nc := *c
+	nc.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	return &nc
+}
+
+// ExpiryLeeway is how long before its expiry a token is considered invalid
+// by Valid, to allow for clock skew and request latency.
+const ExpiryLeeway = time.Minute
+
+// ExpiresAt returns the time a token that expires in d expires, or the zero
+// time if d is not positive. Lyft documents expires_in for every token, so a
+// missing or zero expires_in means the expiry is unknown rather than that
+// the token has already expired.
+func ExpiresAt(d time.Duration) time.Time {
+	if d <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(d)
+}
+
+// Valid reports whether accessToken is non-empty and expiresAt is more than
+// ExpiryLeeway in the future. A zero expiresAt means the expiry is unknown,
+// and the token is considered valid.
+func Valid(accessToken string, expiresAt time.Time) bool {
+	if accessToken == "" {
+		return false
+	}
+	return expiresAt.IsZero() || time.Now().Add(ExpiryLeeway).Before(expiresAt)
+}
+
+// Token has the fields of the subpackages' token types, and is used for
+// their JSON encoding. RefreshToken is empty for token types that have no
+// refresh token, and is then omitted from the encoding.
+type Token struct {
+	AccessToken  string
+	RefreshToken string
+	TokenType    string
+	Expires      time.Duration
+	ExpiresAt    time.Time
+	Scopes       []string
+}
+
+// storedToken is the JSON encoding of Token.
+type storedToken struct {
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	TokenType    string    `json:"token_type"`
+	Expires      int64     `json:"expires_in"` // seconds
+	ExpiresAt    time.Time `json:"expires_at"`
+	Scopes       string    `json:"scope"` // space delimited
+}
+
+// MarshalJSON encodes t using the field names of Lyft's token responses,
+// with ExpiresAt in RFC 3339 format.
+func (t Token) MarshalJSON() ([]byte, error) {
+	return json.Marshal(storedToken{
+		AccessToken:  t.AccessToken,
+		RefreshToken: t.RefreshToken,
+		TokenType:    t.TokenType,
+		Expires:      int64(t.Expires / time.Second),
+		ExpiresAt:    t.ExpiresAt,
+		Scopes:       strings.Join(t.Scopes, " "),
+	})
+}
+
+// UnmarshalJSON decodes a token encoded by MarshalJSON.
+func (t *Token) UnmarshalJSON(p []byte) error {
+	var aux storedToken
+	if err := json.Unmarshal(p, &aux); err != nil {
+		return err
+	}
+	t.AccessToken = aux.AccessToken
+	t.RefreshToken = aux.RefreshToken
+	t.TokenType = aux.TokenType
+	t.Expires = time.Second * time.Duration(aux.Expires)
+	t.ExpiresAt = aux.ExpiresAt
+	t.Scopes = strings.Fields(aux.Scopes)
+	return nil
+}
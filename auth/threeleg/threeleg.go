@@ -1,5 +1,9 @@
 // Package threeleg provides functions for working with the three-legged
 // OAuth flow described at https://developer.lyft.com/v1/docs/authentication#section-3-legged-flow-for-accessing-user-specific-endpoints.
+//
+// Requests to the token endpoints do not follow redirects, regardless of the
+// supplied http.Client's CheckRedirect policy, so that the client credentials
+// are never sent anywhere other than the supplied base URL.
 package threeleg
 
 import (
@@ -10,9 +14,11 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/nishanths/lyft-go"
+	"github.com/nishanths/lyft-go/auth/internal/oauth"
 )
 
 // AuthorizationURL constructs the URL that a user should be directed to, in order for the user
@@ -34,12 +40,14 @@ func AuthorizationCode(r *http.Request) string {
 	return r.FormValue("code")
 }
 
-// Token is returned by GenerateToken.
+// Token is returned by GenerateToken. A Token can be encoded as JSON, for
+// example to persist it across process restarts.
 type Token struct {
 	AccessToken  string
 	RefreshToken string
 	TokenType    string
 	Expires      time.Duration
+	ExpiresAt    time.Time // Computed from Expires when the token was generated; zero if Expires is zero.
 	Scopes       []string
 }
 
@@ -51,11 +59,13 @@ type token struct {
 	Scopes       string `json:"scope"`      // space delimited
 }
 
-// RefreshedToken is returned by RefreshToken.
+// RefreshedToken is returned by RefreshToken. Like Token, it can be encoded
+// as JSON.
 type RefreshedToken struct {
 	AccessToken string
 	TokenType   string
 	Expires     time.Duration
+	ExpiresAt   time.Time // Computed from Expires when the token was refreshed; zero if Expires is zero.
 	Scopes      []string
 }
 
@@ -66,6 +76,78 @@ type refreshedToken struct {
 	Scopes      string `json:"scope"`      // space delimited
 }
 
+// Valid reports whether the token has an access token and does not expire
+// within a minute. A token with a zero ExpiresAt is valid, since its expiry
+// is unknown.
+func (t Token) Valid() bool {
+	return oauth.Valid(t.AccessToken, t.ExpiresAt)
+}
+
+// Valid reports whether the token has an access token and does not expire
+// within a minute. A token with a zero ExpiresAt is valid, since its expiry
+// is unknown.
+func (t RefreshedToken) Valid() bool {
+	return oauth.Valid(t.AccessToken, t.ExpiresAt)
+}
+
+// MarshalJSON encodes the token, including ExpiresAt, so that it can be
+// stored and later decoded using UnmarshalJSON.
+func (t Token) MarshalJSON() ([]byte, error) {
+	return json.Marshal(oauth.Token{
+		AccessToken:  t.AccessToken,
+		RefreshToken: t.RefreshToken,
+		TokenType:    t.TokenType,
+		Expires:      t.Expires,
+		ExpiresAt:    t.ExpiresAt,
+		Scopes:       t.Scopes,
+	})
+}
+
+// UnmarshalJSON decodes a token encoded by MarshalJSON.
+func (t *Token) UnmarshalJSON(p []byte) error {
+	var aux oauth.Token
+	if err := json.Unmarshal(p, &aux); err != nil {
+		return err
+	}
+	*t = Token{
+		AccessToken:  aux.AccessToken,
+		RefreshToken: aux.RefreshToken,
+		TokenType:    aux.TokenType,
+		Expires:      aux.Expires,
+		ExpiresAt:    aux.ExpiresAt,
+		Scopes:       aux.Scopes,
+	}
+	return nil
+}
+
+// MarshalJSON encodes the token, including ExpiresAt, so that it can be
+// stored and later decoded using UnmarshalJSON.
+func (t RefreshedToken) MarshalJSON() ([]byte, error) {
+	return json.Marshal(oauth.Token{
+		AccessToken: t.AccessToken,
+		TokenType:   t.TokenType,
+		Expires:     t.Expires,
+		ExpiresAt:   t.ExpiresAt,
+		Scopes:      t.Scopes,
+	})
+}
+
+// UnmarshalJSON decodes a token encoded by MarshalJSON.
+func (t *RefreshedToken) UnmarshalJSON(p []byte) error {
+	var aux oauth.Token
+	if err := json.Unmarshal(p, &aux); err != nil {
+		return err
+	}
+	*t = RefreshedToken{
+		AccessToken: aux.AccessToken,
+		TokenType:   aux.TokenType,
+		Expires:     aux.Expires,
+		ExpiresAt:   aux.ExpiresAt,
+		Scopes:      aux.Scopes,
+	}
+	return nil
+}
+
 // GenerateToken creates a new access token using the authorization code
 // obtained from Lyft's authorization redirect. The access token
 // returned can be used in lyft.Client. baseURL is typically lyft.BaseURL.
@@ -78,7 +160,7 @@ func GenerateToken(c *http.Client, baseURL, clientID, clientSecret, code string)
 	r.Header.Set("Content-Type", "application/json")
 	r.SetBasicAuth(clientID, clientSecret)
 
-	rsp, err := c.Do(r)
+	rsp, err := oauth.NoRedirect(c).Do(r)
 	if err != nil {
 		return Token{}, nil, err
 	}
@@ -92,11 +174,13 @@ func GenerateToken(c *http.Client, baseURL, clientID, clientSecret, code string)
 	if err := unmarshal(rsp.Body, &g); err != nil {
 		return Token{}, rsp.Header, err
 	}
+	expires := time.Second * time.Duration(g.Expires)
 	return Token{
 		AccessToken:  g.AccessToken,
 		RefreshToken: g.RefreshToken,
 		TokenType:    g.TokenType,
-		Expires:      time.Second * time.Duration(g.Expires),
+		Expires:      expires,
+		ExpiresAt:    oauth.ExpiresAt(expires),
 		Scopes:       strings.Fields(g.Scopes),
 	}, rsp.Header, nil
 }
@@ -104,6 +188,9 @@ func GenerateToken(c *http.Client, baseURL, clientID, clientSecret, code string)
 // RefreshToken refreshes the access token associated with refreshToken.
 // See Token for obtaining access/refresh token pairs.
 // baseURL is typically lyft.BaseURL.
+//
+// If Lyft is throttling token requests, the error will be a *lyft.StatusError
+// whose RetryAfter field indicates how long to wait before trying again.
 func RefreshToken(c *http.Client, baseURL, clientID, clientSecret, refreshToken string) (RefreshedToken, http.Header, error) {
 	body := fmt.Sprintf(`{"grant_type": "refresh_token", "refresh_token": "%s"}`, refreshToken)
 	r, err := http.NewRequest("POST", baseURL+"/oauth/token", strings.NewReader(body))
@@ -113,7 +200,7 @@ func RefreshToken(c *http.Client, baseURL, clientID, clientSecret, refreshToken
 	r.Header.Set("Content-Type", "application/json")
 	r.SetBasicAuth(clientID, clientSecret)
 
-	rsp, err := c.Do(r)
+	rsp, err := oauth.NoRedirect(c).Do(r)
 	if err != nil {
 		return RefreshedToken{}, nil, err
 	}
@@ -127,14 +214,86 @@ func RefreshToken(c *http.Client, baseURL, clientID, clientSecret, refreshToken
 	if err := unmarshal(rsp.Body, &ref); err != nil {
 		return RefreshedToken{}, rsp.Header, err
 	}
+	expires := time.Second * time.Duration(ref.Expires)
 	return RefreshedToken{
 		AccessToken: ref.AccessToken,
 		TokenType:   ref.TokenType,
-		Expires:     time.Second * time.Duration(ref.Expires),
+		Expires:     expires,
+		ExpiresAt:   oauth.ExpiresAt(expires),
 		Scopes:      strings.Fields(ref.Scopes),
 	}, rsp.Header, nil
 }
 
+var _ lyft.TokenSource = (*RefreshingSource)(nil)
+
+// RefreshingSource is a lyft.TokenSource that uses RefreshToken to obtain
+// access tokens. The access token is cached and refreshed shortly before it
+// expires, or after a lyft.Client gets a response with status code 401 for
+// it; see Invalidate. If Lyft doesn't report when the token expires, it is
+// only refreshed after a 401. If Lyft throttles a refresh, the error is
+// returned without refreshing until the time in its RetryAfter field has
+// passed. It is safe for concurrent use.
+type RefreshingSource struct {
+	c                      *http.Client
+	baseURL                string
+	clientID, clientSecret string
+	refreshToken           string
+
+	mu      sync.Mutex // protects the fields below
+	token   RefreshedToken
+	err     error     // from a throttled refresh
+	retryAt time.Time // when to refresh again after err
+}
+
+// NewRefreshingSource returns a RefreshingSource that refreshes the access
+// token associated with refreshToken. The arguments are as for RefreshToken.
+// The first access token is obtained on the first call to Token.
+func NewRefreshingSource(c *http.Client, baseURL, clientID, clientSecret, refreshToken string) *RefreshingSource {
+	return &RefreshingSource{
+		c:            c,
+		baseURL:      baseURL,
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		refreshToken: refreshToken,
+	}
+}
+
+// Token returns the cached access token, refreshing it first if it isn't
+// Valid. If refreshing fails, the error is returned and the next call tries
+// again, unless the error is a *lyft.StatusError with a RetryAfter duration:
+// then the same error is returned until the duration has passed.
+func (s *RefreshingSource) Token() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token.Valid() {
+		return s.token.AccessToken, nil
+	}
+	if s.err != nil && time.Now().Before(s.retryAt) {
+		return "", s.err
+	}
+	s.err = nil
+	t, _, err := RefreshToken(s.c, s.baseURL, s.clientID, s.clientSecret, s.refreshToken)
+	if err != nil {
+		if se, ok := err.(*lyft.StatusError); ok && se.RetryAfter > 0 {
+			s.err, s.retryAt = err, time.Now().Add(se.RetryAfter)
+		}
+		return "", err
+	}
+	s.token = t
+	return t.AccessToken, nil
+}
+
+// Invalidate drops the cached access token if it is token, so that the next
+// call to Token refreshes it. A lyft.Client calls Invalidate when a response
+// has status code 401.
+func (s *RefreshingSource) Invalidate(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token.AccessToken == token {
+		s.token = RefreshedToken{}
+	}
+}
+
 // RevokeToken revokes the supplied access token.
 // baseURL is typically lyft.BaseURL.
 func RevokeToken(c *http.Client, baseURL, clientID, clientSecret, accessToken string) (http.Header, error) {
@@ -148,7 +307,7 @@ func RevokeToken(c *http.Client, baseURL, clientID, clientSecret, accessToken st
 	r.Header.Set("Content-Type", "application/json")
 	r.SetBasicAuth(clientID, clientSecret)
 
-	rsp, err := c.Do(r)
+	rsp, err := oauth.NoRedirect(c).Do(r)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,275 @@
+package threeleg
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nishanths/lyft-go"
+)
+
+// newTokenServer returns a server that responds to refresh requests with a
+// new access token that expires in expiresIn seconds. The count of refresh
+// requests is stored in n.
+func newTokenServer(t *testing.T, expiresIn int, n *int32) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		i := atomic.AddInt32(n, 1)
+		fmt.Fprintf(w, `{"access_token": "access%d", "token_type": "Bearer", "expires_in": %d, "scope": "public"}`, i, expiresIn)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestRefreshingSourceCaches(t *testing.T) {
+	var n int32
+	srv := newTokenServer(t, 3600, &n)
+	s := NewRefreshingSource(srv.Client(), srv.URL, "id", "secret", "refresh")
+
+	for i := 0; i < 3; i++ {
+		tok, err := s.Token()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tok != "access1" {
+			t.Errorf("token %d = %q, want access1", i, tok)
+		}
+	}
+	if n != 1 {
+		t.Errorf("refreshes = %d, want 1", n)
+	}
+}
+
+func TestRefreshingSourceRefreshesBeforeExpiry(t *testing.T) {
+	var n int32
+	// The token expires within oauth.ExpiryLeeway, so each call refreshes it.
+	srv := newTokenServer(t, 30, &n)
+	s := NewRefreshingSource(srv.Client(), srv.URL, "id", "secret", "refresh")
+
+	for i := 1; i <= 3; i++ {
+		tok, err := s.Token()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := fmt.Sprintf("access%d", i); tok != want {
+			t.Errorf("token %d = %q, want %q", i, tok, want)
+		}
+	}
+}
+
+func TestRefreshingSourceConcurrent(t *testing.T) {
+	var n int32
+	srv := newTokenServer(t, 3600, &n)
+	s := NewRefreshingSource(srv.Client(), srv.URL, "id", "secret", "refresh")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := s.Token(); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n != 1 {
+		t.Errorf("refreshes = %d, want 1", n)
+	}
+}
+
+func TestRefreshingSourceInvalidate(t *testing.T) {
+	var n int32
+	srv := newTokenServer(t, 3600, &n)
+	s := NewRefreshingSource(srv.Client(), srv.URL, "id", "secret", "refresh")
+
+	tok, err := s.Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Invalidate("other")
+	if tok2, _ := s.Token(); tok2 != tok {
+		t.Errorf("after invalidating another token: token = %q, want %q", tok2, tok)
+	}
+	s.Invalidate(tok)
+	if tok2, _ := s.Token(); tok2 != "access2" {
+		t.Errorf("after invalidating the token: token = %q, want access2", tok2)
+	}
+}
+
+func TestNoRedirect(t *testing.T) {
+	var leaked int32
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&leaked, 1)
+	}))
+	defer target.Close()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, target.URL+r.URL.Path, http.StatusTemporaryRedirect)
+	}))
+	defer srv.Close()
+
+	check := func(name string, err error) {
+		t.Helper()
+		if se, ok := err.(*lyft.StatusError); !ok || se.StatusCode != http.StatusTemporaryRedirect {
+			t.Errorf("%s: err = %v, want *lyft.StatusError with status code 307", name, err)
+		}
+	}
+	_, _, err := GenerateToken(srv.Client(), srv.URL, "id", "secret", "code")
+	check("GenerateToken", err)
+	_, _, err = RefreshToken(srv.Client(), srv.URL, "id", "secret", "refresh")
+	check("RefreshToken", err)
+	_, err = RevokeToken(srv.Client(), srv.URL, "id", "secret", "access")
+	check("RevokeToken", err)
+	if leaked != 0 {
+		t.Errorf("redirect was followed %d times", leaked)
+	}
+}
+
+func TestRefreshingSourceUnknownExpiry(t *testing.T) {
+	var n int32
+	srv := newTokenServer(t, 0, &n)
+	s := NewRefreshingSource(srv.Client(), srv.URL, "id", "secret", "refresh")
+
+	for i := 0; i < 3; i++ {
+		if _, err := s.Token(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n != 1 {
+		t.Errorf("refreshes = %d, want 1", n)
+	}
+	s.Invalidate("access1")
+	if tok, err := s.Token(); err != nil || tok != "access2" {
+		t.Errorf("token after Invalidate = %q, %v; want access2", tok, err)
+	}
+}
+
+func TestTokenJSON(t *testing.T) {
+	want := Token{
+		AccessToken:  "access",
+		RefreshToken: "refresh",
+		TokenType:    "Bearer",
+		Expires:      time.Hour,
+		ExpiresAt:    time.Date(2017, 11, 5, 12, 0, 0, 0, time.UTC),
+		Scopes:       []string{"public", "rides.read"},
+	}
+	p, err := json.Marshal(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got Token
+	if err := json.Unmarshal(p, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("round trip = %+v, want %+v", got, want)
+	}
+}
+
+func TestRefreshedTokenJSON(t *testing.T) {
+	want := RefreshedToken{
+		AccessToken: "access",
+		TokenType:   "Bearer",
+		Expires:     time.Hour,
+		ExpiresAt:   time.Date(2017, 11, 5, 12, 0, 0, 0, time.UTC),
+		Scopes:      []string{"public"},
+	}
+	p, err := json.Marshal(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(p), "refresh_token") {
+		t.Errorf("encoding %s has a refresh_token", p)
+	}
+	var got RefreshedToken
+	if err := json.Unmarshal(p, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("round trip = %+v, want %+v", got, want)
+	}
+}
+
+func TestTokenValid(t *testing.T) {
+	tests := []struct {
+		name string
+		tok  Token
+		want bool
+	}{
+		{"no access token", Token{ExpiresAt: time.Now().Add(time.Hour)}, false},
+		{"expires later", Token{AccessToken: "a", ExpiresAt: time.Now().Add(time.Hour)}, true},
+		{"expires soon", Token{AccessToken: "a", ExpiresAt: time.Now().Add(30 * time.Second)}, false},
+		{"expired", Token{AccessToken: "a", ExpiresAt: time.Now().Add(-time.Hour)}, false},
+		{"unknown expiry", Token{AccessToken: "a"}, true},
+	}
+	for _, tt := range tests {
+		if got := tt.tok.Valid(); got != tt.want {
+			t.Errorf("%s: Valid = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRefreshingSourceThrottled(t *testing.T) {
+	var n int32
+	var throttle int32 = 1
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&n, 1)
+		if atomic.LoadInt32(&throttle) == 1 {
+			w.Header().Set("Retry-After", "120")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		fmt.Fprint(w, `{"access_token": "access", "token_type": "Bearer", "expires_in": 3600}`)
+	}))
+	defer srv.Close()
+	s := NewRefreshingSource(srv.Client(), srv.URL, "id", "secret", "refresh")
+
+	for i := 0; i < 3; i++ {
+		_, err := s.Token()
+		se, ok := err.(*lyft.StatusError)
+		if !ok || se.StatusCode != http.StatusTooManyRequests || se.RetryAfter != 2*time.Minute {
+			t.Fatalf("call %d: err = %v, want *lyft.StatusError with RetryAfter 2m0s", i, err)
+		}
+	}
+	if n != 1 {
+		t.Errorf("refreshes while throttled = %d, want 1", n)
+	}
+
+	// Once RetryAfter has passed, the next call refreshes.
+	atomic.StoreInt32(&throttle, 0)
+	s.mu.Lock()
+	s.retryAt = time.Now().Add(-time.Second)
+	s.mu.Unlock()
+	if tok, err := s.Token(); err != nil || tok != "access" {
+		t.Errorf("Token after RetryAfter = %q, %v; want access", tok, err)
+	}
+	if n != 2 {
+		t.Errorf("refreshes = %d, want 2", n)
+	}
+}
+
+func TestRefreshingSourceErrorNotThrottled(t *testing.T) {
+	var n int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&n, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+	s := NewRefreshingSource(srv.Client(), srv.URL, "id", "secret", "refresh")
+
+	for i := 0; i < 2; i++ {
+		if _, err := s.Token(); err == nil {
+			t.Fatal("expected error")
+		}
+	}
+	if n != 2 {
+		t.Errorf("refreshes = %d, want 2", n)
+	}
+}
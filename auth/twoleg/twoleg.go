@@ -1,5 +1,9 @@
 // Package twoleg provides functions for working with the two-legged
 // OAuth flow described at https://developer.lyft.com/v1/docs/authentication#section-client-credentials-2-legged-flow-for-public-endpoints.
+//
+// Requests to the token endpoint do not follow redirects, regardless of the
+// supplied http.Client's CheckRedirect policy, so that the client credentials
+// are never sent anywhere other than the supplied base URL.
 package twoleg
 
 import (
@@ -11,16 +15,54 @@ import (
 	"time"
 
 	"github.com/nishanths/lyft-go"
+	"github.com/nishanths/lyft-go/auth/internal/oauth"
 )
 
-// Token is returned by GenerateToken.
+// Token is returned by GenerateToken. A Token can be encoded as JSON, for
+// example to persist it across process restarts.
 type Token struct {
 	AccessToken string
 	TokenType   string
 	Expires     time.Duration
+	ExpiresAt   time.Time // Computed from Expires when the token was generated; zero if Expires is zero.
 	Scopes      []string
 }
 
+// Valid reports whether the token has an access token and does not expire
+// within a minute. A token with a zero ExpiresAt is valid, since its expiry
+// is unknown.
+func (t Token) Valid() bool {
+	return oauth.Valid(t.AccessToken, t.ExpiresAt)
+}
+
+// MarshalJSON encodes the token, including ExpiresAt, so that it can be
+// stored and later decoded using UnmarshalJSON.
+func (t Token) MarshalJSON() ([]byte, error) {
+	return json.Marshal(oauth.Token{
+		AccessToken: t.AccessToken,
+		TokenType:   t.TokenType,
+		Expires:     t.Expires,
+		ExpiresAt:   t.ExpiresAt,
+		Scopes:      t.Scopes,
+	})
+}
+
+// UnmarshalJSON decodes a token encoded by MarshalJSON.
+func (t *Token) UnmarshalJSON(p []byte) error {
+	var aux oauth.Token
+	if err := json.Unmarshal(p, &aux); err != nil {
+		return err
+	}
+	*t = Token{
+		AccessToken: aux.AccessToken,
+		TokenType:   aux.TokenType,
+		Expires:     aux.Expires,
+		ExpiresAt:   aux.ExpiresAt,
+		Scopes:      aux.Scopes,
+	}
+	return nil
+}
+
 type token struct {
 	AccessToken string `json:"access_token"`
 	TokenType   string `json:"token_type"`
@@ -31,6 +73,9 @@ type token struct {
 // GenerateToken creates a new access token.
 // The access token returned can be used in lyft.Client.
 // baseURL is typically lyft.BaseURL.
+//
+// If Lyft is throttling token requests, the error will be a *lyft.StatusError
+// whose RetryAfter field indicates how long to wait before trying again.
 func GenerateToken(c *http.Client, baseURL, clientID, clientSecret string) (Token, http.Header, error) {
 	const body = `{"grant_type": "client_credentials", "scope": "public"}`
 	r, err := http.NewRequest("POST", baseURL+"/oauth/token", strings.NewReader(body))
@@ -40,7 +85,7 @@ func GenerateToken(c *http.Client, baseURL, clientID, clientSecret string) (Toke
 	r.Header.Set("Content-Type", "application/json")
 	r.SetBasicAuth(clientID, clientSecret)
 
-	rsp, err := c.Do(r)
+	rsp, err := oauth.NoRedirect(c).Do(r)
 	if err != nil {
 		return Token{}, nil, err
 	}
@@ -54,10 +99,12 @@ func GenerateToken(c *http.Client, baseURL, clientID, clientSecret string) (Toke
 	if err := unmarshal(rsp.Body, &g); err != nil {
 		return Token{}, rsp.Header, err
 	}
+	expires := time.Second * time.Duration(g.Expires)
 	return Token{
 		AccessToken: g.AccessToken,
 		TokenType:   g.TokenType,
-		Expires:     time.Second * time.Duration(g.Expires),
+		Expires:     expires,
+		ExpiresAt:   oauth.ExpiresAt(expires),
 		Scopes:      strings.Fields(g.Scopes),
 	}, rsp.Header, nil
 }
@@ -0,0 +1,94 @@
+package twoleg
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/nishanths/lyft-go"
+)
+
+func TestGenerateTokenNoRedirect(t *testing.T) {
+	var leaked bool
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		leaked = true
+	}))
+	defer target.Close()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, target.URL+"/oauth/token", http.StatusFound)
+	}))
+	defer srv.Close()
+
+	_, _, err := GenerateToken(srv.Client(), srv.URL, "id", "secret")
+	if se, ok := err.(*lyft.StatusError); !ok || se.StatusCode != http.StatusFound {
+		t.Errorf("err = %v, want *lyft.StatusError with status code 302", err)
+	}
+	if leaked {
+		t.Error("redirect was followed")
+	}
+}
+
+func TestTokenJSON(t *testing.T) {
+	want := Token{
+		AccessToken: "access",
+		TokenType:   "Bearer",
+		Expires:     time.Hour,
+		ExpiresAt:   time.Date(2017, 11, 5, 12, 0, 0, 0, time.UTC),
+		Scopes:      []string{"public"},
+	}
+	p, err := json.Marshal(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got Token
+	if err := json.Unmarshal(p, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("round trip = %+v, want %+v", got, want)
+	}
+}
+
+func TestGenerateTokenExpiry(t *testing.T) {
+	for _, expiresIn := range []int{0, 86400} {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, `{"access_token": "access", "token_type": "Bearer", "expires_in": %d, "scope": "public"}`, expiresIn)
+		}))
+		tok, _, err := GenerateToken(srv.Client(), srv.URL, "id", "secret")
+		srv.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expiresIn == 0 && !tok.ExpiresAt.IsZero() {
+			t.Errorf("expires_in 0: ExpiresAt = %v, want zero", tok.ExpiresAt)
+		}
+		if expiresIn > 0 && time.Until(tok.ExpiresAt) <= 23*time.Hour {
+			t.Errorf("expires_in %d: ExpiresAt = %v, want about a day from now", expiresIn, tok.ExpiresAt)
+		}
+		if !tok.Valid() {
+			t.Errorf("expires_in %d: Valid = false, want true", expiresIn)
+		}
+	}
+}
+
+func TestGenerateTokenThrottled(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(`{"error": "rate_limited"}`))
+	}))
+	defer srv.Close()
+
+	_, _, err := GenerateToken(srv.Client(), srv.URL, "id", "secret")
+	se, ok := err.(*lyft.StatusError)
+	if !ok || se.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("err = %v, want *lyft.StatusError with status code 429", err)
+	}
+	if se.RetryAfter != 30*time.Second {
+		t.Errorf("RetryAfter = %v, want 30s", se.RetryAfter)
+	}
+}
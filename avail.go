@@ -1,10 +1,15 @@
 package lyft
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
+	"fmt"
+	"math"
 	"net/http"
 	"net/url"
+	"sort"
 	"strconv"
+	"sync"
 	"time"
 )
 
@@ -15,9 +20,63 @@ type RideType struct {
 	ImageURL    string  `json:"image_url"`
 	Pricing     Pricing `json:"pricing_details"`
 	Seats       int     `json:"seats"`
+	// Current primetime for the ride type at the location. Empty/zero if
+	// there is no primetime or if the response doesn't include it.
+	PrimetimePercentage string  `json:"primetime_percentage"`
+	PrimetimeMultiplier float64 `json:"primetime_multiplier"`
+	// Whether the ride type can currently be requested at the location
+	// (for example, false if there are no drivers). True if the response
+	// doesn't include it.
+	Available bool `json:"can_request"`
 }
 
+func (r *RideType) UnmarshalJSON(p []byte) error {
+	type rideType RideType // Prevents recursion.
+	aux := rideType{Available: true}
+	if err := JSONUnmarshal(p, &aux); err != nil {
+		return err
+	}
+	*r = RideType(aux)
+	return nil
+}
+
+// rideTypeList is a list of ride types that may be represented in JSON
+// as either an array or a single object.
+type rideTypeList []RideType
+
+func (l *rideTypeList) UnmarshalJSON(p []byte) error {
+	if isJSONNull(p) {
+		*l = nil
+		return nil
+	}
+	if isJSONArray(p) {
+		var s []RideType
+		if err := JSONUnmarshal(p, &s); err != nil {
+			return err
+		}
+		*l = s
+		return nil
+	}
+	var r RideType
+	if err := JSONUnmarshal(p, &r); err != nil {
+		return err
+	}
+	*l = rideTypeList{r}
+	return nil
+}
+
+// Pricing models.
+const (
+	PricingStandard = "standard" // Base charge plus per mile and per minute costs
+	PricingFlatFare = "flat_fare"
+	PricingPerSeat  = "per_seat"
+)
+
 type Pricing struct {
+	// Model is the pricing model, such as PricingStandard. Other models
+	// may be added by Lyft. PricingStandard is used if the response doesn't
+	// include the model.
+	Model           string `json:"pricing_model"`
 	Base            int    `json:"base_charge"`
 	PerMile         int    `json:"cost_per_mile"`
 	PerMinute       int    `json:"cost_per_minute"`
@@ -25,6 +84,40 @@ type Pricing struct {
 	TrustAndService int    `json:"trust_and_service"`
 	Currency        string `json:"currency"`
 	CancelPenalty   int    `json:"cancel_penalty_amount"`
+	// Set only for the corresponding pricing models.
+	FlatFare int `json:"flat_fare"`
+	PerSeat  int `json:"cost_per_seat"`
+}
+
+// WithPrimetime returns the pricing adjusted for primetime with the
+// multiplier, such as a RideType's PrimetimeMultiplier (1.5 for "50%").
+// Primetime applies to the base charge, the per mile and per minute costs,
+// and the flat fare and per seat costs. The minimum cost, the trust and
+// service fee, and the cancellation penalty are not affected. Adjusted
+// amounts are rounded to the nearest minor unit.
+func (p Pricing) WithPrimetime(multiplier float64) Pricing {
+	scale := func(n int) int {
+		return int(math.Round(float64(n) * multiplier))
+	}
+	p.Base = scale(p.Base)
+	p.PerMile = scale(p.PerMile)
+	p.PerMinute = scale(p.PerMinute)
+	p.FlatFare = scale(p.FlatFare)
+	p.PerSeat = scale(p.PerSeat)
+	return p
+}
+
+func (p *Pricing) UnmarshalJSON(b []byte) error {
+	type pricing Pricing // Prevents recursion.
+	var aux pricing
+	if err := JSONUnmarshal(b, &aux); err != nil {
+		return err
+	}
+	if aux.Model == "" {
+		aux.Model = PricingStandard
+	}
+	*p = Pricing(aux)
+	return nil
 }
 
 func formatFloat(n float64) string {
@@ -36,79 +129,269 @@ func formatFloat(n float64) string {
 // ride type only. If no ride types are available, the error will
 // be a StatusError.
 func (c *Client) RideTypes(lat, lng float64, rideType string) ([]RideType, http.Header, error) {
+	return c.RideTypesContext(context.Background(), lat, lng, rideType)
+}
+
+// RideTypesContext is like RideTypes, but the request is made with the
+// supplied context. If the context is done, the error wraps the context's
+// error.
+func (c *Client) RideTypesContext(ctx context.Context, lat, lng float64, rideType string) ([]RideType, http.Header, error) {
+	types, h, err := c.rideTypes(ctx, lat, lng, rideType)
+	return types, h, contextError(ctx, err)
+}
+
+func (c *Client) rideTypes(ctx context.Context, lat, lng float64, rideType string) ([]RideType, http.Header, error) {
 	vals := make(url.Values)
 	vals.Set("lat", formatFloat(lat))
 	vals.Set("lng", formatFloat(lng))
 	if rideType != "" {
 		vals.Set("ride_type", rideType)
 	}
-	r, err := http.NewRequest("GET", c.base()+"/v1/ridetypes?"+vals.Encode(), nil)
+	var response struct {
+		RideTypes rideTypeList `json:"ride_types"` // Lyft may return a single object if there is one ride type
+	}
+	h, err := c.getJSON(ctx, "/v1/ridetypes", vals, &response)
 	if err != nil {
-		return nil, nil, err
+		return nil, h, err
+	}
+	return response.RideTypes, h, nil
+}
+
+// usualSpotPrecision is the number of decimal places that coordinates are
+// rounded to by UsualSpots. Two decimal places is roughly 1 km.
+const usualSpotPrecision = 100
+
+// UsualSpots returns up to n of the most frequent origins of the rides, such
+// as the rides returned by RideHistory, in descending order of frequency.
+// Origins are grouped by rounding their coordinates to two decimal places
+// (roughly 1 km), and the rounded coordinates are returned.
+// Origins whose latitude and longitude are both zero are skipped. If n is
+// zero or negative, UsualSpots returns nil.
+func UsualSpots(rides []RideDetail, n int) []Location {
+	if n <= 0 {
+		return nil
+	}
+	counts := make(map[Location]int)
+	for _, r := range rides {
+		if r.Origin.Latitude == 0 && r.Origin.Longitude == 0 {
+			continue
+		}
+		l := Location{
+			Latitude:  math.Round(r.Origin.Latitude*usualSpotPrecision) / usualSpotPrecision,
+			Longitude: math.Round(r.Origin.Longitude*usualSpotPrecision) / usualSpotPrecision,
+		}
+		counts[l]++
 	}
 
-	rsp, err := c.do(r)
-	if err != nil {
-		return nil, nil, err
+	spots := make([]Location, 0, len(counts))
+	for l := range counts {
+		spots = append(spots, l)
+	}
+	sort.Slice(spots, func(i, j int) bool {
+		a, b := spots[i], spots[j]
+		if counts[a] != counts[b] {
+			return counts[a] > counts[b]
+		}
+		if a.Latitude != b.Latitude {
+			return a.Latitude < b.Latitude
+		}
+		return a.Longitude < b.Longitude
+	})
+	if len(spots) > n {
+		spots = spots[:n]
 	}
-	defer rsp.Body.Close()
+	return spots
+}
+
+// maxConcurrentRequests is the maximum number of concurrent requests
+// made by methods that make multiple requests.
+const maxConcurrentRequests = 4
 
-	if rsp.StatusCode != 200 {
-		return nil, rsp.Header, NewStatusError(rsp)
+// RideTypesAtUsualSpots returns the ride types available at each of the
+// locations returned by UsualSpots(rides, n). At most a few requests are made
+// concurrently. If any request fails (for instance, because of the rate
+// limit), the remaining requests are canceled and the first error
+// is returned.
+func (c *Client) RideTypesAtUsualSpots(parent context.Context, rides []RideDetail, n int) (map[Location][]RideType, error) {
+	spots := UsualSpots(rides, n)
+
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex // protects ret and firstErr
+		ret      = make(map[Location][]RideType, len(spots))
+		firstErr error
+		sem      = make(chan struct{}, maxConcurrentRequests)
+	)
+	for _, l := range spots {
+		wg.Add(1)
+		go func(l Location) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			types, _, err := c.rideTypes(ctx, l.Latitude, l.Longitude, "")
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+					cancel()
+				}
+				return
+			}
+			ret[l] = types
+		}(l)
 	}
+	wg.Wait()
 
-	var response struct {
-		RideTypes []RideType `json:"ride_types"`
+	if firstErr != nil {
+		return nil, contextError(parent, firstErr)
 	}
-	if err := unmarshal(rsp.Body, &response); err != nil {
-		return nil, rsp.Header, err
+	return ret, nil
+}
+
+// RideTypesForPartySize returns the ride types available at the location
+// that have at least size seats. The returned slice is empty, and the error
+// is nil, if no available ride type has enough seats.
+func (c *Client) RideTypesForPartySize(lat, lng float64, size int) ([]RideType, http.Header, error) {
+	return c.RideTypesForPartySizeContext(context.Background(), lat, lng, size)
+}
+
+// RideTypesForPartySizeContext is like RideTypesForPartySize, but the request is made with the
+// supplied context. If the context is done, the error wraps the context's
+// error.
+func (c *Client) RideTypesForPartySizeContext(ctx context.Context, lat, lng float64, size int) ([]RideType, http.Header, error) {
+	types, h, err := c.rideTypes(ctx, lat, lng, "")
+	if err != nil {
+		return nil, h, contextError(ctx, err)
+	}
+	ret := []RideType{}
+	for _, t := range types {
+		if t.Seats >= size {
+			ret = append(ret, t)
+		}
 	}
-	return response.RideTypes, rsp.Header, nil
+	return ret, h, nil
 }
 
 // CostEstimate is returned by the client's CostEstimates method.
 type CostEstimate struct {
-	RideType       string
-	DisplayName    string
-	MaximumCost    int           // Estimated maximum cost of the ride.
-	MinimumCost    int           // Estimated minimum cost of the ride.
-	Distance       float64       // Estimated distance of the ride; in miles.
-	Duration       time.Duration // Estimated duration of the ride.
-	PrimetimeToken string        // DEPRECATED; see CostToken and https://developer.lyft.com/reference#availability-ride-estimates.
+	RideType    string
+	DisplayName string
+	MaximumCost int           // Estimated maximum cost of the ride.
+	MinimumCost int           // Estimated minimum cost of the ride.
+	Currency    string        // Currency of MaximumCost and MinimumCost.
+	Distance    float64       // Estimated distance of the ride; in miles.
+	Duration    time.Duration // Estimated duration of the ride.
+	// Primetime percentage for the ride type, such as "25%".
+	// Empty if there is no primetime.
+	PrimetimePercentage string
+	// Deprecated: Lyft has replaced the primetime confirmation token with
+	// the cost token; see https://developer.lyft.com/reference#availability-ride-estimates.
+	// Use Token instead.
+	PrimetimeToken string
 	CostToken      string
 	Valid          bool // If false, MaximumCost and MinimumCost may be invalid.
+	// Details of the cost token, such as the primetime multiplier and how
+	// long the token is valid. Nil unless the estimate includes the token
+	// duration.
+	Cost *CostTokenInfo
+}
+
+// Token returns the token to use in a RideRequest's CostToken field. It is
+// CostToken, or PrimetimeToken if Lyft only returned the legacy token.
+func (r *CostEstimate) Token() string {
+	if r.CostToken != "" {
+		return r.CostToken
+	}
+	return r.PrimetimeToken
+}
+
+// ErrInvalidEstimate is returned by CostEstimate.ToRequest if the estimate
+// isn't valid.
+var ErrInvalidEstimate = errors.New("invalid cost estimate")
+
+// ToRequest returns a request for a ride of the estimate's ride type
+// from origin to dest, with the CostToken field set to the estimate's token.
+// The error is ErrInvalidEstimate if the estimate's Valid field is false.
+func (r *CostEstimate) ToRequest(origin, dest Location) (RideRequest, error) {
+	if !r.Valid {
+		return RideRequest{}, ErrInvalidEstimate
+	}
+	return RideRequest{
+		Origin:      origin,
+		Destination: dest,
+		RideType:    r.RideType,
+		CostToken:   r.Token(),
+	}, nil
 }
 
 func (r *CostEstimate) UnmarshalJSON(p []byte) error {
 	// Auxiliary type for unmarshaling.
 	// This type corresponds to "cost_estimates" in the Lyft API reference.
 	type costEstimate struct {
-		RideType       string  `json:"ride_type"`
-		DisplayName    string  `json:"display_name"`
-		MaximumCost    int     `json:"estimated_cost_cents_max"`
-		MinimumCost    int     `json:"estimated_cost_cents_min"`
-		Distance       float64 `json:"estimated_distance_miles"`
-		Duration       int64   `json:"estimated_duration_seconds"` // Documented as int in API reference.
-		PrimetimeToken string  `json:"primetime_confirmation_token"`
-		CostToken      string  `json:"cost_token"`
-		Valid          bool    `json:"is_valid_estimate"`
+		RideType            string        `json:"ride_type"`
+		DisplayName         string        `json:"display_name"`
+		MaximumCost         int           `json:"estimated_cost_cents_max"`
+		MinimumCost         int           `json:"estimated_cost_cents_min"`
+		Currency            string        `json:"currency"`
+		Distance            float64       `json:"estimated_distance_miles"`
+		Duration            int64         `json:"estimated_duration_seconds"` // Documented as int in API reference.
+		PrimetimePercentage string        `json:"primetime_percentage"`
+		PrimetimeMultiplier float64       `json:"primetime_multiplier"`
+		PrimetimeToken      string        `json:"primetime_confirmation_token"`
+		CostToken           string        `json:"cost_token"`
+		TokenDuration       tokenDuration `json:"token_duration"`
+		Valid               bool          `json:"is_valid_estimate"`
 	}
 	var aux costEstimate
-	if err := json.Unmarshal(p, &aux); err != nil {
+	if err := JSONUnmarshal(p, &aux); err != nil {
 		return err
 	}
 	r.RideType = aux.RideType
 	r.DisplayName = aux.DisplayName
 	r.MaximumCost = aux.MaximumCost
 	r.MinimumCost = aux.MinimumCost
+	r.Currency = aux.Currency
 	r.Distance = aux.Distance
 	r.Duration = time.Second * time.Duration(aux.Duration)
+	r.PrimetimePercentage = aux.PrimetimePercentage
 	r.PrimetimeToken = aux.PrimetimeToken
 	r.CostToken = aux.CostToken
 	r.Valid = aux.Valid
+	if aux.TokenDuration.valid {
+		r.Cost = &CostTokenInfo{
+			PrimetimePercentage: aux.PrimetimePercentage,
+			PrimetimeMultiplier: aux.PrimetimeMultiplier,
+			PrimetimeToken:      aux.PrimetimeToken,
+			CostToken:           aux.CostToken,
+			TokenDuration:       aux.TokenDuration.d,
+		}
+	}
 	return nil
 }
 
+// DisplayCost returns the estimate's minimum and maximum cost converted to
+// the specified currency using cv. The costs are returned unconverted if cv is nil
+// or if the estimate is already in the specified currency. See CurrencyConverter.
+func (r *CostEstimate) DisplayCost(cv CurrencyConverter, currency string) (min, max Money, err error) {
+	min = Money{Amount: r.MinimumCost, Currency: r.Currency}
+	max = Money{Amount: r.MaximumCost, Currency: r.Currency}
+	if cv == nil || currency == r.Currency {
+		return min, max, nil
+	}
+	if min, err = cv.Convert(min, currency); err != nil {
+		return Money{}, Money{}, err
+	}
+	if max, err = cv.Convert(max, currency); err != nil {
+		return Money{}, Money{}, err
+	}
+	return min, max, nil
+}
+
 // IgnoreArg is a sentinel value that can be used when calling a function
 // that has an optional float64 argument.
 const IgnoreArg float64 = -181 // so that valid longitudes aren't ignored.
@@ -117,7 +400,22 @@ const IgnoreArg float64 = -181 // so that valid longitudes aren't ignored.
 // The end locations are optional and are ignored if the value equals
 // the package-level const IgnoreArg. rideType is also optional; if it is set, estimates
 // will be returned for the specified type only.
+//
+// Lyft sometimes responds with an error object despite a 200 status code;
+// such responses result in a *StatusError.
 func (c *Client) CostEstimates(startLat, startLng, endLat, endLng float64, rideType string) ([]CostEstimate, http.Header, error) {
+	return c.CostEstimatesContext(context.Background(), startLat, startLng, endLat, endLng, rideType)
+}
+
+// CostEstimatesContext is like CostEstimates, but the request is made with the
+// supplied context. If the context is done, the error wraps the context's
+// error.
+func (c *Client) CostEstimatesContext(ctx context.Context, startLat, startLng, endLat, endLng float64, rideType string) ([]CostEstimate, http.Header, error) {
+	estimates, h, err := c.costEstimates(ctx, startLat, startLng, endLat, endLng, c.rideType(rideType))
+	return estimates, h, contextError(ctx, err)
+}
+
+func (c *Client) costEstimates(ctx context.Context, startLat, startLng, endLat, endLng float64, rideType string) ([]CostEstimate, http.Header, error) {
 	vals := make(url.Values)
 	vals.Set("start_lat", formatFloat(startLat))
 	vals.Set("start_lng", formatFloat(startLng))
@@ -128,30 +426,146 @@ func (c *Client) CostEstimates(startLat, startLng, endLat, endLng float64, rideT
 		vals.Set("end_lng", formatFloat(endLng))
 	}
 	if rideType != "" {
-		vals.Set("ride_type", formatFloat(endLng))
+		vals.Set("ride_type", rideType)
+	}
+	var response struct {
+		C []CostEstimate `json:"cost_estimates"`
 	}
-	r, err := http.NewRequest("GET", c.base()+"/v1/cost?"+vals.Encode(), nil)
+	h, err := c.send(ctx, request{
+		method:         "GET",
+		path:           "/v1/cost",
+		query:          vals,
+		checkErrorBody: true,
+	}, &response)
 	if err != nil {
-		return nil, nil, err
+		return nil, h, err
 	}
+	return response.C, h, nil
+}
 
-	rsp, err := c.do(r)
+// CostEstimateForRide returns the current cost estimate for a trip with
+// the same origin, destination, and ride type as the ride, such as a ride
+// returned by RideHistory. It is useful for showing the current cost of
+// repeating a past trip. If the ride has no destination coordinates, the
+// estimate is for the origin only. It is an error for the ride to have no
+// origin coordinates or no ride type.
+func (c *Client) CostEstimateForRide(ctx context.Context, ride *RideDetail) (CostEstimate, error) {
+	if ride.Origin.Latitude == 0 && ride.Origin.Longitude == 0 {
+		return CostEstimate{}, errors.New("ride has no origin coordinates")
+	}
+	if ride.RideType == "" {
+		return CostEstimate{}, errors.New("ride has no ride type")
+	}
+	endLat, endLng := ride.Destination.Latitude, ride.Destination.Longitude
+	if endLat == 0 && endLng == 0 {
+		endLat, endLng = IgnoreArg, IgnoreArg
+	}
+	estimates, _, err := c.costEstimates(ctx, ride.Origin.Latitude, ride.Origin.Longitude, endLat, endLng, "")
 	if err != nil {
-		return nil, nil, err
+		return CostEstimate{}, contextError(ctx, err)
 	}
-	defer rsp.Body.Close()
+	for _, e := range estimates {
+		if e.RideType == ride.RideType {
+			return e, nil
+		}
+	}
+	return CostEstimate{}, fmt.Errorf("no cost estimate for ride type %q", ride.RideType)
+}
 
-	if rsp.StatusCode != 200 {
-		return nil, rsp.Header, NewStatusError(rsp)
+// RideOption is a ride type and its cost estimate, returned by MergeEstimates.
+type RideOption struct {
+	RideType RideType
+	Estimate *CostEstimate // Nil if there is no estimate for the ride type.
+	// Whether the ride type can be requested: the ride type is available
+	// and it has a valid estimate.
+	Available bool
+}
+
+// MergeEstimates returns an option for each of the ride types, such as the
+// ride types returned by RideTypes, with the ride type's estimate from the
+// estimates, such as those returned by CostEstimates. The options are in the
+// order of the ride types, followed by options for estimates whose ride
+// type isn't in types; the RideType of such options has only the RideType,
+// DisplayName, and Available fields set. This is useful for showing every ride type,
+// with the unavailable ones marked, since Lyft may omit estimates for some
+// ride types.
+func MergeEstimates(types []RideType, estimates []CostEstimate) []RideOption {
+	byType := make(map[string]*CostEstimate, len(estimates))
+	for i := range estimates {
+		if _, ok := byType[estimates[i].RideType]; !ok {
+			byType[estimates[i].RideType] = &estimates[i]
+		}
 	}
 
-	var response struct {
-		C []CostEstimate `json:"cost_estimates"`
+	ret := make([]RideOption, 0, len(types))
+	seen := make(map[string]bool, len(types))
+	for _, t := range types {
+		e := byType[t.RideType]
+		seen[t.RideType] = true
+		ret = append(ret, RideOption{
+			RideType:  t,
+			Estimate:  e,
+			Available: t.Available && e != nil && e.Valid,
+		})
 	}
-	if err := unmarshal(rsp.Body, &response); err != nil {
-		return nil, rsp.Header, err
+	for i := range estimates {
+		e := &estimates[i]
+		if seen[e.RideType] {
+			continue
+		}
+		seen[e.RideType] = true
+		ret = append(ret, RideOption{
+			RideType:  RideType{RideType: e.RideType, DisplayName: e.DisplayName, Available: true},
+			Estimate:  e,
+			Available: e.Valid,
+		})
 	}
-	return response.C, rsp.Header, nil
+	return ret
+}
+
+// PrimetimeSample is a primetime percentage recorded by SamplePrimetime.
+type PrimetimeSample struct {
+	Time                time.Time
+	RideType            string
+	PrimetimePercentage string // Empty if there was no primetime.
+}
+
+// SamplePrimetime records the primetime percentage at the location over time.
+// It polls the cost estimates at the location n times, waiting interval
+// between polls, and records a sample for each ride type in each poll.
+// rideType is optional; if it is set, only the specified type is sampled.
+//
+// Polls that fail because of the rate limit are skipped. If a poll fails for
+// another reason, or if the context is done, the samples recorded so far are
+// returned along with the error.
+func (c *Client) SamplePrimetime(ctx context.Context, lat, lng float64, rideType string, interval time.Duration, n int) ([]PrimetimeSample, error) {
+	var samples []PrimetimeSample
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			if err := c.wait(ctx, interval); err != nil {
+				return samples, contextError(ctx, err)
+			}
+		}
+		estimates, _, err := c.costEstimates(ctx, lat, lng, IgnoreArg, IgnoreArg, rideType)
+		if err := ctx.Err(); err != nil {
+			return samples, contextError(ctx, err)
+		}
+		if IsRateLimit(err) {
+			continue
+		}
+		if err != nil {
+			return samples, contextError(ctx, err)
+		}
+		now := time.Now()
+		for _, e := range estimates {
+			samples = append(samples, PrimetimeSample{
+				Time:                now,
+				RideType:            e.RideType,
+				PrimetimePercentage: e.PrimetimePercentage,
+			})
+		}
+	}
+	return samples, nil
 }
 
 // ETAEstimate is returned by the client's DriverETA method.
@@ -171,7 +585,7 @@ func (e *ETAEstimate) UnmarshalJSON(p []byte) error {
 		Valid       bool   `json:"is_valid_estimate"`
 	}
 	var aux etaEstimate
-	if err := json.Unmarshal(p, &aux); err != nil {
+	if err := JSONUnmarshal(p, &aux); err != nil {
 		return err
 	}
 	e.RideType = aux.RideType
@@ -181,11 +595,39 @@ func (e *ETAEstimate) UnmarshalJSON(p []byte) error {
 	return nil
 }
 
+// FormatETA formats the ETA for display, as the number of minutes rounded
+// to the nearest minute, such as "2 min". ETAs under a minute are formatted
+// as "Arriving now". Zero and negative ETAs, which are treated as unknown,
+// are formatted as "—".
+func FormatETA(d time.Duration) string {
+	switch {
+	case d <= 0:
+		return "—"
+	case d < time.Minute:
+		return "Arriving now"
+	}
+	return strconv.FormatInt(int64(d.Round(time.Minute)/time.Minute), 10) + " min"
+}
+
 // DriverETA estimates the time for the nearest driver to reach the specifed location.
 // The end locations are optional and are ignored if the value equals the
 // package-level const IgnoreArg. The rideType argument is also optional. If set,
 // estimates will be returned for the specified type only.
+// As with CostEstimates, a 200 response with an error object results in
+// a *StatusError.
 func (c *Client) DriverETA(startLat, startLng, endLat, endLng float64, rideType string) ([]ETAEstimate, http.Header, error) {
+	return c.DriverETAContext(context.Background(), startLat, startLng, endLat, endLng, rideType)
+}
+
+// DriverETAContext is like DriverETA, but the request is made with the
+// supplied context. If the context is done, the error wraps the context's
+// error.
+func (c *Client) DriverETAContext(ctx context.Context, startLat, startLng, endLat, endLng float64, rideType string) ([]ETAEstimate, http.Header, error) {
+	estimates, h, err := c.driverETA(ctx, startLat, startLng, endLat, endLng, c.rideType(rideType))
+	return estimates, h, contextError(ctx, err)
+}
+
+func (c *Client) driverETA(ctx context.Context, startLat, startLng, endLat, endLng float64, rideType string) ([]ETAEstimate, http.Header, error) {
 	vals := make(url.Values)
 	vals.Set("lat", formatFloat(startLat))
 	vals.Set("lng", formatFloat(startLng))
@@ -196,30 +638,66 @@ func (c *Client) DriverETA(startLat, startLng, endLat, endLng float64, rideType
 		vals.Set("destination_lng", formatFloat(endLng))
 	}
 	if rideType != "" {
-		vals.Set("ride_type", formatFloat(endLng))
+		vals.Set("ride_type", rideType)
+	}
+	var response struct {
+		E []ETAEstimate `json:"eta_estimates"`
 	}
-	r, err := http.NewRequest("GET", c.base()+"/v1/eta?"+vals.Encode(), nil)
+	h, err := c.send(ctx, request{
+		method:         "GET",
+		path:           "/v1/eta",
+		query:          vals,
+		checkErrorBody: true,
+	}, &response)
 	if err != nil {
-		return nil, nil, err
+		return nil, h, err
 	}
+	return response.E, h, nil
+}
 
-	rsp, err := c.do(r)
+// ErrNoRideAvailable is returned by SoonestRide if no ride type has a valid
+// ETA at the origin.
+var ErrNoRideAvailable = errors.New("no ride available")
+
+// SoonestRide returns the ride type whose nearest driver can reach the
+// origin soonest, and the driver's ETA. The destination is optional; it is
+// ignored if its Latitude and Longitude are both zero (as for Location{}), or
+// if either equals IgnoreArg. Estimates
+// that aren't valid are skipped. The error is ErrNoRideAvailable if there is
+// no valid estimate.
+//
+// If the ride type is missing from the ride types at the origin, the returned
+// RideType has only the RideType and DisplayName fields set.
+func (c *Client) SoonestRide(ctx context.Context, origin, dest Location) (RideType, time.Duration, error) {
+	endLat, endLng := dest.Latitude, dest.Longitude
+	if (endLat == 0 && endLng == 0) || endLat == IgnoreArg || endLng == IgnoreArg {
+		endLat, endLng = IgnoreArg, IgnoreArg
+	}
+	estimates, _, err := c.driverETA(ctx, origin.Latitude, origin.Longitude, endLat, endLng, "")
 	if err != nil {
-		return nil, nil, err
+		return RideType{}, 0, contextError(ctx, err)
 	}
-	defer rsp.Body.Close()
-
-	if rsp.StatusCode != 200 {
-		return nil, rsp.Header, NewStatusError(rsp)
+	var best *ETAEstimate
+	for i := range estimates {
+		e := &estimates[i]
+		if e.Valid && (best == nil || e.ETA < best.ETA) {
+			best = e
+		}
+	}
+	if best == nil {
+		return RideType{}, 0, ErrNoRideAvailable
 	}
 
-	var response struct {
-		E []ETAEstimate `json:"eta_estimates"`
+	types, _, err := c.rideTypes(ctx, origin.Latitude, origin.Longitude, best.RideType)
+	if err != nil {
+		return RideType{}, 0, contextError(ctx, err)
 	}
-	if err := unmarshal(rsp.Body, &response); err != nil {
-		return nil, rsp.Header, err
+	for _, t := range types {
+		if t.RideType == best.RideType {
+			return t, best.ETA, nil
+		}
 	}
-	return response.E, rsp.Header, nil
+	return RideType{RideType: best.RideType, DisplayName: best.DisplayName}, best.ETA, nil
 }
 
 // NearbyDriver is returned by the client's DriversNearby method.
@@ -229,39 +707,87 @@ type NearbyDriver struct {
 }
 
 type Driver struct {
-	Locations []LatLng `json:"locations"` // Most recent coordinates (TODO: but in which order? WTF, Lyft API docs)
+	Locations []Location `json:"locations"` // Most recent coordinates (TODO: but in which order? WTF, Lyft API docs). The Address field will not be set.
 }
 
-type LatLng struct {
-	Latitude  float64 `json:"lat"`
-	Longitude float64 `json:"lng"`
-}
+// LatLng is an alias for Location, which it was previously a separate
+// type from.
+//
+// Deprecated: Use Location.
+type LatLng = Location
 
 // DriversNearby returns the location of drivers near a location.
 func (c *Client) DriversNearby(lat, lng float64) ([]NearbyDriver, http.Header, error) {
+	return c.DriversNearbyContext(context.Background(), lat, lng)
+}
+
+// DriversNearbyContext is like DriversNearby, but the request is made with the
+// supplied context. If the context is done, the error wraps the context's
+// error.
+func (c *Client) DriversNearbyContext(ctx context.Context, lat, lng float64) ([]NearbyDriver, http.Header, error) {
+	drivers, h, err := c.driversNearby(ctx, lat, lng)
+	return drivers, h, contextError(ctx, err)
+}
+
+func (c *Client) driversNearby(ctx context.Context, lat, lng float64) ([]NearbyDriver, http.Header, error) {
 	vals := make(url.Values)
 	vals.Set("lat", formatFloat(lat))
 	vals.Set("lng", formatFloat(lng))
-	r, err := http.NewRequest("GET", c.base()+"/v1/drivers?"+vals.Encode(), nil)
+	var response struct {
+		N []NearbyDriver `json:"nearby_drivers"`
+	}
+	h, err := c.getJSON(ctx, "/v1/drivers", vals, &response)
 	if err != nil {
-		return nil, nil, err
+		return nil, h, err
 	}
+	return response.N, h, nil
+}
 
-	rsp, err := c.do(r)
-	if err != nil {
-		return nil, nil, err
+// DriverCount is the number of nearby drivers for a ride type, returned by
+// CountNearbyDrivers.
+type DriverCount struct {
+	RideType RideType
+	Drivers  int
+}
+
+// CountNearbyDrivers returns the number of drivers in nearby, such as the
+// result of DriversNearby, for each of the ride types, such as the result
+// of RideTypes. The counts are in the order of the ride types, followed by
+// counts for the ride types in nearby that aren't in types; the RideType of
+// such counts has only the RideType field set.
+func CountNearbyDrivers(types []RideType, nearby []NearbyDriver) []DriverCount {
+	counts := make(map[string]int, len(nearby))
+	for _, n := range nearby {
+		counts[n.RideType] += len(n.Drivers)
 	}
-	defer rsp.Body.Close()
 
-	if rsp.StatusCode != 200 {
-		return nil, rsp.Header, NewStatusError(rsp)
+	ret := make([]DriverCount, 0, len(types))
+	seen := make(map[string]bool, len(types))
+	for _, t := range types {
+		seen[t.RideType] = true
+		ret = append(ret, DriverCount{RideType: t, Drivers: counts[t.RideType]})
+	}
+	for _, n := range nearby {
+		if seen[n.RideType] {
+			continue
+		}
+		seen[n.RideType] = true
+		ret = append(ret, DriverCount{RideType: RideType{RideType: n.RideType}, Drivers: counts[n.RideType]})
 	}
+	return ret
+}
 
-	var response struct {
-		N []NearbyDriver `json:"nearby_drivers"`
+// DriverCounts returns the number of nearby drivers for each ride type
+// available at the location, using RideTypes and DriversNearby. See
+// CountNearbyDrivers.
+func (c *Client) DriverCounts(ctx context.Context, lat, lng float64) ([]DriverCount, error) {
+	types, _, err := c.rideTypes(ctx, lat, lng, "")
+	if err != nil {
+		return nil, contextError(ctx, err)
 	}
-	if err := unmarshal(rsp.Body, &response); err != nil {
-		return nil, rsp.Header, err
+	nearby, _, err := c.driversNearby(ctx, lat, lng)
+	if err != nil {
+		return nil, contextError(ctx, err)
 	}
-	return response.N, rsp.Header, nil
+	return CountNearbyDrivers(types, nearby), nil
 }
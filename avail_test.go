@@ -0,0 +1,766 @@
+package lyft
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"reflect"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testHistory has three rides around one location and two rides around
+// another.
+var testHistory = []RideDetail{
+	{RideID: "1", Origin: RideLocation{Latitude: 37.7712, Longitude: -122.4194}},
+	{RideID: "2", Origin: RideLocation{Latitude: 37.7721, Longitude: -122.4189}},
+	{RideID: "3", Origin: RideLocation{Latitude: 37.7689, Longitude: -122.4201}},
+	{RideID: "4", Origin: RideLocation{Latitude: 37.8044, Longitude: -122.2712}},
+	{RideID: "5", Origin: RideLocation{Latitude: 37.8041, Longitude: -122.2708}},
+	{RideID: "6"}, // no origin
+}
+
+func TestUsualSpots(t *testing.T) {
+	want := []Location{
+		{Latitude: 37.77, Longitude: -122.42},
+		{Latitude: 37.80, Longitude: -122.27},
+	}
+	if got := UsualSpots(testHistory, 5); !reflect.DeepEqual(got, want) {
+		t.Errorf("UsualSpots(5) = %v, want %v", got, want)
+	}
+	if got := UsualSpots(testHistory, 1); !reflect.DeepEqual(got, want[:1]) {
+		t.Errorf("UsualSpots(1) = %v, want %v", got, want[:1])
+	}
+	for _, n := range []int{0, -1} {
+		if got := UsualSpots(testHistory, n); got != nil {
+			t.Errorf("UsualSpots(%d) = %v, want nil", n, got)
+		}
+	}
+}
+
+func TestRideTypesAtUsualSpots(t *testing.T) {
+	var mu sync.Mutex
+	var queried []string
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		queried = append(queried, r.URL.Query().Get("lat")+","+r.URL.Query().Get("lng"))
+		mu.Unlock()
+		w.Write([]byte(`{"ride_types": [{"ride_type": "lyft", "display_name": "Lyft"}]}`))
+	})
+
+	got, err := c.RideTypesAtUsualSpots(bg, testHistory, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || len(queried) != 2 {
+		t.Fatalf("got %d locations with %d requests, want 2", len(got), len(queried))
+	}
+	for l, types := range got {
+		if len(types) != 1 || types[0].RideType != RideTypeLyft {
+			t.Errorf("ride types at %v = %+v", l, types)
+		}
+	}
+}
+
+// costEstimatesBody is the example response for GET /v1/cost in the Lyft API
+// reference.
+const costEstimatesBody = `{
+  "cost_estimates": [
+    {
+      "ride_type": "lyft_plus",
+      "estimated_duration_seconds": 913,
+      "estimated_distance_miles": 3.29,
+      "estimated_cost_cents_max": 2355,
+      "primetime_percentage": "25%",
+      "currency": "USD",
+      "estimated_cost_cents_min": 1561,
+      "display_name": "Lyft Plus",
+      "primetime_confirmation_token": null,
+      "cost_token": null,
+      "is_valid_estimate": true
+    }
+  ]
+}`
+
+func TestCostEstimatesDecode(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(costEstimatesBody))
+	})
+	estimates, _, err := c.CostEstimates(37.7763, -122.3918, 37.7972, -122.4533, RideTypePlus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(estimates) != 1 {
+		t.Fatalf("len(estimates) = %d, want 1", len(estimates))
+	}
+	e := estimates[0]
+	if want := 913 * time.Second; e.Duration != want {
+		t.Errorf("Duration = %v, want %v", e.Duration, want)
+	}
+	if e.Distance != 3.29 || e.MinimumCost != 1561 || e.MaximumCost != 2355 || !e.Valid {
+		t.Errorf("estimate = %+v", e)
+	}
+}
+
+func TestCostEstimatesQuery(t *testing.T) {
+	var query url.Values
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		w.Write([]byte(costEstimatesBody))
+	})
+	if _, _, err := c.CostEstimates(37.7763, -122.3918, 37.7972, -122.4533, RideTypePlus); err != nil {
+		t.Fatal(err)
+	}
+	want := url.Values{
+		"start_lat": {"37.7763"}, "start_lng": {"-122.3918"},
+		"end_lat": {"37.7972"}, "end_lng": {"-122.4533"},
+		"ride_type": {"lyft_plus"},
+	}
+	if !reflect.DeepEqual(query, want) {
+		t.Errorf("query = %v, want %v", query, want)
+	}
+
+	if _, _, err := c.CostEstimates(37.7763, -122.3918, IgnoreArg, IgnoreArg, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := query["ride_type"]; ok {
+		t.Errorf("query = %v, want no ride_type", query)
+	}
+}
+
+func TestDriverETAQuery(t *testing.T) {
+	var query url.Values
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		w.Write([]byte(`{"eta_estimates": [{"ride_type": "lyft_plus", "display_name": "Lyft Plus", "eta_seconds": 120, "is_valid_estimate": true}]}`))
+	})
+	estimates, _, err := c.DriverETA(37.7763, -122.3918, 37.7972, -122.4533, RideTypePlus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := url.Values{
+		"lat": {"37.7763"}, "lng": {"-122.3918"},
+		"destination_lat": {"37.7972"}, "destination_lng": {"-122.4533"},
+		"ride_type": {"lyft_plus"},
+	}
+	if !reflect.DeepEqual(query, want) {
+		t.Errorf("query = %v, want %v", query, want)
+	}
+	if len(estimates) != 1 || estimates[0].RideType != RideTypePlus {
+		t.Errorf("estimates = %+v", estimates)
+	}
+}
+
+func TestSamplePrimetimeDeadline(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(costEstimatesBody))
+	})
+	before := runtime.NumGoroutine()
+
+	ctx, cancel := context.WithTimeout(bg, 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	samples, err := c.SamplePrimetime(ctx, 37.7763, -122.3918, "", time.Hour, 3)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("returned after %v, want promptly after the deadline", elapsed)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want context.DeadlineExceeded", err)
+	}
+	if len(samples) != 1 {
+		t.Errorf("samples = %+v, want the first poll's sample", samples)
+	}
+	checkGoroutines(t, c, before)
+}
+
+func TestCostEstimateDecodeSurge(t *testing.T) {
+	const fields = `"ride_type": "lyft", "currency": "USD", "estimated_cost_cents_min": 1250, "estimated_cost_cents_max": 1500,
+		"primetime_percentage": "50%", "primetime_multiplier": 1.5, "cost_token": "cost", "is_valid_estimate": true`
+	want := &CostTokenInfo{
+		PrimetimePercentage: "50%",
+		PrimetimeMultiplier: 1.5,
+		CostToken:           "cost",
+		TokenDuration:       5 * time.Minute,
+	}
+	tests := []struct {
+		name string
+		body string
+		want *CostTokenInfo
+	}{
+		{"string duration", `{` + fields + `, "token_duration": "300"}`, want},
+		{"numeric duration", `{` + fields + `, "token_duration": 300}`, want},
+		{"no duration", `{` + fields + `}`, nil},
+		{"null duration", `{` + fields + `, "token_duration": null}`, nil},
+	}
+	for _, tt := range tests {
+		var e CostEstimate
+		if err := json.Unmarshal([]byte(tt.body), &e); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if e.PrimetimePercentage != "50%" || e.Token() != "cost" || e.MinimumCost != 1250 {
+			t.Errorf("%s: estimate = %+v", tt.name, e)
+		}
+		if !reflect.DeepEqual(e.Cost, tt.want) {
+			t.Errorf("%s: Cost = %+v, want %+v", tt.name, e.Cost, tt.want)
+		}
+	}
+
+	var e CostEstimate
+	if err := json.Unmarshal([]byte(`{`+fields+`, "token_duration": "soon"}`), &e); err == nil {
+		t.Error("invalid token duration: expected error")
+	}
+}
+
+func TestSoonestRide(t *testing.T) {
+	const etas = `{"eta_estimates": [
+		{"ride_type": "lyft", "display_name": "Lyft", "eta_seconds": 300, "is_valid_estimate": true},
+		{"ride_type": "lyft_line", "display_name": "Lyft Line", "eta_seconds": 60, "is_valid_estimate": false},
+		{"ride_type": "lyft_plus", "display_name": "Lyft Plus", "eta_seconds": 120, "is_valid_estimate": true},
+		{"ride_type": "lyft_premier", "display_name": "Lyft Premier", "eta_seconds": 600, "is_valid_estimate": true}
+	]}`
+	tests := []struct {
+		name      string
+		dest      Location
+		rideTypes string
+		etaQuery  url.Values // checked for the destination
+		want      RideType
+		err       error
+	}{
+		{
+			name:      "soonest valid",
+			dest:      Location{Latitude: 37.79, Longitude: -122.39},
+			rideTypes: `{"ride_types": [{"ride_type": "lyft_plus", "display_name": "Lyft Plus", "seats": 6}]}`,
+			etaQuery:  url.Values{"destination_lat": {"37.79"}, "destination_lng": {"-122.39"}},
+			want:      RideType{RideType: RideTypePlus, DisplayName: "Lyft Plus", Seats: 6, Available: true},
+		},
+		{
+			name:      "zero destination",
+			rideTypes: `{"ride_types": []}`,
+			want:      RideType{RideType: RideTypePlus, DisplayName: "Lyft Plus"},
+		},
+		{
+			name:      "IgnoreArg destination",
+			dest:      Location{Latitude: IgnoreArg, Longitude: -122.39},
+			rideTypes: `{"ride_types": []}`,
+			want:      RideType{RideType: RideTypePlus, DisplayName: "Lyft Plus"},
+		},
+	}
+	for _, tt := range tests {
+		var query url.Values
+		c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/v1/eta":
+				query = r.URL.Query()
+				w.Write([]byte(etas))
+			case "/v1/ridetypes":
+				w.Write([]byte(tt.rideTypes))
+			}
+		})
+		rt, eta, err := c.SoonestRide(bg, Location{Latitude: 37.77, Longitude: -122.41}, tt.dest)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(rt, tt.want) || eta != 2*time.Minute {
+			t.Errorf("%s: SoonestRide = %+v, %v; want %+v, 2m0s", tt.name, rt, eta, tt.want)
+		}
+		for _, k := range []string{"destination_lat", "destination_lng"} {
+			if got, want := query.Get(k), tt.etaQuery.Get(k); got != want {
+				t.Errorf("%s: %s = %q, want %q", tt.name, k, got, want)
+			}
+		}
+	}
+}
+
+func TestSoonestRideNoneValid(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"eta_estimates": [
+			{"ride_type": "lyft", "eta_seconds": 300, "is_valid_estimate": false},
+			{"ride_type": "lyft_plus", "eta_seconds": 120, "is_valid_estimate": false}
+		]}`))
+	})
+	if _, _, err := c.SoonestRide(bg, Location{Latitude: 37.77, Longitude: -122.41}, Location{}); err != ErrNoRideAvailable {
+		t.Errorf("err = %v, want ErrNoRideAvailable", err)
+	}
+}
+
+func TestSamplePrimetime(t *testing.T) {
+	// Each poll returns the next response; "429" is a rate limited poll.
+	responses := []string{
+		`{"cost_estimates": [{"ride_type": "lyft", "primetime_percentage": "0%"}, {"ride_type": "lyft_plus", "primetime_percentage": "25%"}]}`,
+		"429",
+		`{"cost_estimates": [{"ride_type": "lyft", "primetime_percentage": "50%"}, {"ride_type": "lyft_plus", "primetime_percentage": ""}]}`,
+	}
+	var polls int
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		body := responses[polls]
+		polls++
+		if body == "429" {
+			w.WriteHeader(429)
+			return
+		}
+		w.Write([]byte(body))
+	})
+	var sleeps []time.Duration
+	c.sleep = func(ctx context.Context, d time.Duration) error {
+		sleeps = append(sleeps, d)
+		return nil
+	}
+
+	samples, err := c.SamplePrimetime(bg, 37.7, -122.2, "", time.Minute, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, s := range samples {
+		got = append(got, s.RideType+"="+s.PrimetimePercentage)
+		if s.Time.IsZero() {
+			t.Errorf("sample %+v has no time", s)
+		}
+	}
+	want := []string{"lyft=0%", "lyft_plus=25%", "lyft=50%", "lyft_plus="}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("samples = %q, want %q", got, want)
+	}
+	if polls != 3 {
+		t.Errorf("polls = %d, want 3", polls)
+	}
+	if want := []time.Duration{time.Minute, time.Minute}; !reflect.DeepEqual(sleeps, want) {
+		t.Errorf("sleeps = %v, want %v", sleeps, want)
+	}
+}
+
+func TestSamplePrimetimeError(t *testing.T) {
+	var polls int
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		polls++
+		if polls == 2 {
+			w.WriteHeader(500)
+			return
+		}
+		w.Write([]byte(`{"cost_estimates": [{"ride_type": "lyft", "primetime_percentage": "25%"}]}`))
+	})
+	c.sleep = func(ctx context.Context, d time.Duration) error { return nil }
+
+	samples, err := c.SamplePrimetime(bg, 37.7, -122.2, RideTypeLyft, time.Minute, 3)
+	if se, ok := err.(*StatusError); !ok || se.StatusCode != 500 {
+		t.Errorf("err = %v, want *StatusError with status code 500", err)
+	}
+	if len(samples) != 1 || polls != 2 {
+		t.Errorf("samples, polls = %+v, %d; want the first poll's sample, 2", samples, polls)
+	}
+}
+
+func TestCostEstimateToken(t *testing.T) {
+	tests := []struct {
+		body string
+		want string
+	}{
+		{`{"ride_type": "lyft", "cost_token": "cost", "primetime_confirmation_token": "legacy"}`, "cost"},
+		{`{"ride_type": "lyft", "primetime_confirmation_token": "legacy"}`, "legacy"},
+		{`{"ride_type": "lyft"}`, ""},
+	}
+	for _, tt := range tests {
+		var e CostEstimate
+		if err := json.Unmarshal([]byte(tt.body), &e); err != nil {
+			t.Errorf("%s: %v", tt.body, err)
+			continue
+		}
+		if got := e.Token(); got != tt.want {
+			t.Errorf("%s: Token = %q, want %q", tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestRideTypeListDecode(t *testing.T) {
+	tests := []struct {
+		body string
+		want []string // ride types
+	}{
+		{`{"ride_types": [{"ride_type": "lyft"}, {"ride_type": "lyft_plus"}]}`, []string{RideTypeLyft, RideTypePlus}},
+		{`{"ride_types": {"ride_type": "lyft"}}`, []string{RideTypeLyft}},
+		{`{"ride_types": []}`, []string{}},
+		{`{"ride_types": null}`, nil},
+	}
+	for _, tt := range tests {
+		var v struct {
+			RideTypes rideTypeList `json:"ride_types"`
+		}
+		if err := json.Unmarshal([]byte(tt.body), &v); err != nil {
+			t.Errorf("%s: %v", tt.body, err)
+			continue
+		}
+		if (v.RideTypes == nil) != (tt.want == nil) || len(v.RideTypes) != len(tt.want) {
+			t.Errorf("%s: RideTypes = %+v, want %q", tt.body, v.RideTypes, tt.want)
+			continue
+		}
+		for i, r := range v.RideTypes {
+			if r.RideType != tt.want[i] {
+				t.Errorf("%s: RideTypes[%d] = %q, want %q", tt.body, i, r.RideType, tt.want[i])
+			}
+		}
+	}
+}
+
+// rateConverter converts from USD at a fixed rate per currency.
+type rateConverter map[string]float64
+
+func (c rateConverter) Convert(m Money, currency string) (Money, error) {
+	rate, ok := c[currency]
+	if !ok || m.Currency != "USD" {
+		return Money{}, errors.New("unsupported conversion")
+	}
+	return Money{Amount: int(float64(m.Amount) * rate), Currency: currency}, nil
+}
+
+func TestDisplayCost(t *testing.T) {
+	e := CostEstimate{RideType: RideTypeLyft, MinimumCost: 1000, MaximumCost: 1500, Currency: "USD"}
+	cv := rateConverter{"EUR": 0.9}
+
+	min, max, err := e.DisplayCost(cv, "EUR")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if min != (Money{900, "EUR"}) || max != (Money{1350, "EUR"}) {
+		t.Errorf("EUR: DisplayCost = %+v, %+v", min, max)
+	}
+
+	// No conversion for the estimate's own currency or a nil converter.
+	for _, cv := range []CurrencyConverter{cv, nil} {
+		min, max, err := e.DisplayCost(cv, "USD")
+		if err != nil || min != (Money{1000, "USD"}) || max != (Money{1500, "USD"}) {
+			t.Errorf("USD: DisplayCost = %+v, %+v, %v", min, max, err)
+		}
+	}
+	if min, max, err := e.DisplayCost(nil, "EUR"); err != nil || min.Currency != "USD" || max.Currency != "USD" {
+		t.Errorf("nil converter: DisplayCost = %+v, %+v, %v", min, max, err)
+	}
+
+	if _, _, err := e.DisplayCost(cv, "JPY"); err == nil {
+		t.Error("unsupported currency: expected error")
+	}
+}
+
+func TestRideTypesForPartySize(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"ride_types": [
+			{"ride_type": "lyft_line", "seats": 2},
+			{"ride_type": "lyft", "seats": 4},
+			{"ride_type": "lyft_plus", "seats": 6}
+		]}`))
+	})
+	tests := []struct {
+		size int
+		want []string
+	}{
+		{1, []string{RideTypeLine, RideTypeLyft, RideTypePlus}},
+		{3, []string{RideTypeLyft, RideTypePlus}},
+		{6, []string{RideTypePlus}},
+		{7, []string{}},
+	}
+	for _, tt := range tests {
+		types, _, err := c.RideTypesForPartySize(37.7, -122.2, tt.size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if types == nil {
+			t.Errorf("size %d: ride types are nil, want non-nil", tt.size)
+		}
+		got := []string{}
+		for _, rt := range types {
+			got = append(got, rt.RideType)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("size %d: ride types = %q, want %q", tt.size, got, tt.want)
+		}
+	}
+}
+
+func TestPricingDecode(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want Pricing
+	}{
+		{
+			"standard",
+			`{"base_charge": 200, "cost_per_mile": 115, "cost_per_minute": 23, "cost_minimum": 500,
+				"trust_and_service": 155, "currency": "USD", "cancel_penalty_amount": 500}`,
+			Pricing{Model: PricingStandard, Base: 200, PerMile: 115, PerMinute: 23, Minimum: 500,
+				TrustAndService: 155, Currency: "USD", CancelPenalty: 500},
+		},
+		{
+			"flat fare",
+			`{"pricing_model": "flat_fare", "flat_fare": 1500, "trust_and_service": 155, "currency": "USD", "cancel_penalty_amount": 500}`,
+			Pricing{Model: PricingFlatFare, FlatFare: 1500, TrustAndService: 155, Currency: "USD", CancelPenalty: 500},
+		},
+		{
+			"unknown model",
+			`{"pricing_model": "subscription", "currency": "USD"}`,
+			Pricing{Model: "subscription", Currency: "USD"},
+		},
+	}
+	for _, tt := range tests {
+		var rt RideType
+		if err := json.Unmarshal([]byte(`{"ride_type": "lyft", "pricing_details": `+tt.body+`}`), &rt); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if rt.Pricing != tt.want {
+			t.Errorf("%s: Pricing = %+v, want %+v", tt.name, rt.Pricing, tt.want)
+		}
+	}
+}
+
+func TestCostEstimateToRequest(t *testing.T) {
+	origin := Location{Latitude: 37.77, Longitude: -122.41, Address: "1 Market St"}
+	dest := Location{Latitude: 37.79, Longitude: -122.39}
+	tests := []struct {
+		body  string
+		token string
+	}{
+		{`{"ride_type": "lyft_plus", "is_valid_estimate": true, "cost_token": "cost"}`, "cost"},
+		{`{"ride_type": "lyft_plus", "is_valid_estimate": true, "primetime_confirmation_token": "legacy"}`, "legacy"},
+		{`{"ride_type": "lyft_plus", "is_valid_estimate": true}`, ""},
+	}
+	for _, tt := range tests {
+		var e CostEstimate
+		if err := json.Unmarshal([]byte(tt.body), &e); err != nil {
+			t.Fatal(err)
+		}
+		req, err := e.ToRequest(origin, dest)
+		if err != nil {
+			t.Errorf("%s: %v", tt.body, err)
+			continue
+		}
+		want := RideRequest{Origin: origin, Destination: dest, RideType: RideTypePlus, CostToken: tt.token}
+		if !reflect.DeepEqual(req, want) {
+			t.Errorf("%s: ToRequest = %+v, want %+v", tt.body, req, want)
+		}
+	}
+
+	e := CostEstimate{RideType: RideTypePlus, CostToken: "cost", Valid: false}
+	if req, err := e.ToRequest(origin, dest); err != ErrInvalidEstimate || !reflect.DeepEqual(req, RideRequest{}) {
+		t.Errorf("invalid estimate: ToRequest = %+v, %v, want ErrInvalidEstimate", req, err)
+	}
+}
+
+func TestCostEstimateForRide(t *testing.T) {
+	var queries []url.Values
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.Query())
+		w.Write([]byte(costEstimatesBody))
+	})
+	ride := RideDetail{
+		RideID:      "1",
+		RideType:    RideTypePlus,
+		Origin:      RideLocation{Latitude: 37.7772, Longitude: -122.4233},
+		Destination: RideLocation{Latitude: 37.7972, Longitude: -122.4533},
+	}
+	e, err := c.CostEstimateForRide(bg, &ride)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.RideType != RideTypePlus {
+		t.Errorf("estimate ride type = %q, want %q", e.RideType, RideTypePlus)
+	}
+	want := url.Values{"start_lat": {"37.7772"}, "start_lng": {"-122.4233"}, "end_lat": {"37.7972"}, "end_lng": {"-122.4533"}}
+	if !reflect.DeepEqual(queries[0], want) {
+		t.Errorf("query = %v, want %v", queries[0], want)
+	}
+
+	// Without a destination, the estimate is for the origin only.
+	noDest := ride
+	noDest.Destination = RideLocation{}
+	if _, err := c.CostEstimateForRide(bg, &noDest); err != nil {
+		t.Fatal(err)
+	}
+	if q := queries[1]; q.Get("end_lat") != "" || q.Get("end_lng") != "" || q.Get("start_lat") != "37.7772" {
+		t.Errorf("no destination: query = %v", q)
+	}
+
+	n := len(queries)
+	noOrigin := ride
+	noOrigin.Origin = RideLocation{Address: "1 Market St"}
+	noType := ride
+	noType.RideType = ""
+	for _, r := range []RideDetail{noOrigin, noType} {
+		if _, err := c.CostEstimateForRide(bg, &r); err == nil {
+			t.Errorf("ride %+v: expected error", r)
+		}
+	}
+	if len(queries) != n {
+		t.Errorf("invalid rides: %d requests made, want none", len(queries)-n)
+	}
+
+	other := ride
+	other.RideType = RideTypeLux
+	if _, err := c.CostEstimateForRide(bg, &other); err == nil {
+		t.Error("no estimate for ride type: expected error")
+	}
+}
+
+func TestLocationDecode(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/drivers":
+			w.Write([]byte(`{"nearby_drivers": [{"ride_type": "lyft", "drivers": [
+				{"locations": [{"lat": 37.7749, "lng": -122.4194}, {"lat": 37.775, "lng": -122.4195}]}
+			]}]}`))
+		case "/v1/rides":
+			w.WriteHeader(201)
+			w.Write([]byte(`{"ride_id": "1", "status": "pending",
+				"origin": {"lat": 37.77, "lng": -122.41, "address": "1 Market St"},
+				"destination": {"lat": 37.79, "lng": -122.39, "address": null}}`))
+		}
+	})
+
+	nearby, _, err := c.DriversNearby(37.7749, -122.4194)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []LatLng{{Latitude: 37.7749, Longitude: -122.4194}, {Latitude: 37.775, Longitude: -122.4195}}
+	if len(nearby) != 1 || len(nearby[0].Drivers) != 1 || !reflect.DeepEqual(nearby[0].Drivers[0].Locations, want) {
+		t.Errorf("DriversNearby = %+v, want one driver at %+v", nearby, want)
+	}
+
+	cr, _, err := c.RequestRide(testRideRequest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Location{Latitude: 37.77, Longitude: -122.41, Address: "1 Market St"}); cr.Origin != want {
+		t.Errorf("Origin = %+v, want %+v", cr.Origin, want)
+	}
+	if dest := (Location{Latitude: 37.79, Longitude: -122.39}); cr.Destination != dest {
+		t.Errorf("Destination = %+v, want %+v", cr.Destination, dest)
+	}
+}
+
+func TestPricingWithPrimetime(t *testing.T) {
+	p := Pricing{Model: PricingStandard, Base: 200, PerMile: 115, PerMinute: 23, Minimum: 500,
+		TrustAndService: 155, Currency: "USD", CancelPenalty: 500, FlatFare: 1500, PerSeat: 333}
+	if got := p.WithPrimetime(1.0); got != p {
+		t.Errorf("WithPrimetime(1.0) = %+v, want %+v", got, p)
+	}
+	want := Pricing{Model: PricingStandard, Base: 300, PerMile: 173, PerMinute: 35, Minimum: 500,
+		TrustAndService: 155, Currency: "USD", CancelPenalty: 500, FlatFare: 2250, PerSeat: 500}
+	if got := p.WithPrimetime(1.5); got != want {
+		t.Errorf("WithPrimetime(1.5) = %+v, want %+v", got, want)
+	}
+	if p.Base != 200 {
+		t.Errorf("WithPrimetime modified the receiver: %+v", p)
+	}
+}
+
+func TestRideTypeAvailable(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"ride_types": [
+			{"ride_type": "lyft", "can_request": true},
+			{"ride_type": "lyft_plus", "can_request": false},
+			{"ride_type": "lyft_line"}
+		]}`))
+	})
+	types, _, err := c.RideTypes(37.7, -122.2, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]bool{RideTypeLyft: true, RideTypePlus: false, RideTypeLine: true}
+	if len(types) != len(want) {
+		t.Fatalf("ride types = %+v", types)
+	}
+	for _, rt := range types {
+		if rt.Available != want[rt.RideType] {
+			t.Errorf("%s: Available = %v, want %v", rt.RideType, rt.Available, want[rt.RideType])
+		}
+	}
+}
+
+func TestMergeEstimates(t *testing.T) {
+	types := []RideType{
+		{RideType: RideTypeLyft, DisplayName: "Lyft", Available: true},
+		{RideType: RideTypePlus, DisplayName: "Lyft Plus", Available: true},
+		{RideType: RideTypeLine, DisplayName: "Lyft Line", Available: false},
+		{RideType: RideTypeLux, DisplayName: "Lux", Available: true},
+	}
+	estimates := []CostEstimate{
+		{RideType: RideTypeLux, Valid: false},
+		{RideType: RideTypeLyft, MinimumCost: 1000, Valid: true},
+		{RideType: RideTypeLine, MinimumCost: 500, Valid: true},
+		{RideType: RideTypePremier, DisplayName: "Premier", Valid: true},
+		{RideType: RideTypeLyft, MinimumCost: 9999, Valid: true}, // duplicate, ignored
+	}
+	options := MergeEstimates(types, estimates)
+
+	want := []struct {
+		rideType  string
+		estimate  *CostEstimate
+		available bool
+	}{
+		{RideTypeLyft, &estimates[1], true},
+		{RideTypePlus, nil, false}, // no estimate
+		{RideTypeLine, &estimates[2], false},
+		{RideTypeLux, &estimates[0], false},
+		{RideTypePremier, &estimates[3], true},
+	}
+	if len(options) != len(want) {
+		t.Fatalf("options = %+v, want %d options", options, len(want))
+	}
+	for i, w := range want {
+		o := options[i]
+		if o.RideType.RideType != w.rideType || o.Estimate != w.estimate || o.Available != w.available {
+			t.Errorf("options[%d] = %+v, want ride type %s, estimate %p, available %v", i, o, w.rideType, w.estimate, w.available)
+		}
+	}
+	if premier := options[4].RideType; premier.DisplayName != "Premier" || !premier.Available {
+		t.Errorf("ride type for an estimate without a ride type = %+v", premier)
+	}
+}
+
+func TestFormatETA(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{-time.Minute, "—"},
+		{0, "—"},
+		{30 * time.Second, "Arriving now"},
+		{59 * time.Second, "Arriving now"},
+		{time.Minute, "1 min"},
+		{90 * time.Second, "2 min"},
+		{89 * time.Second, "1 min"},
+		{7*time.Minute + 10*time.Second, "7 min"},
+		{45 * time.Minute, "45 min"},
+	}
+	for _, tt := range tests {
+		if got := FormatETA(tt.d); got != tt.want {
+			t.Errorf("FormatETA(%v) = %q, want %q", tt.d, got, tt.want)
+		}
+	}
+}
+
+func TestCountNearbyDrivers(t *testing.T) {
+	types := []RideType{
+		{RideType: RideTypeLyft, DisplayName: "Lyft", Seats: 4},
+		{RideType: RideTypePlus, DisplayName: "Lyft Plus", Seats: 6},
+	}
+	drivers := func(n int) []Driver { return make([]Driver, n) }
+	nearby := []NearbyDriver{
+		{RideType: RideTypeLyft, Drivers: drivers(3)},
+		{RideType: RideTypeLine, Drivers: drivers(2)},
+		{RideType: RideTypeLyft, Drivers: drivers(1)}, // merged with the first group
+		{RideType: RideTypeLine, Drivers: drivers(0)},
+	}
+	want := []DriverCount{
+		{RideType: types[0], Drivers: 4},
+		{RideType: types[1], Drivers: 0},
+		{RideType: RideType{RideType: RideTypeLine}, Drivers: 2},
+	}
+	if got := CountNearbyDrivers(types, nearby); !reflect.DeepEqual(got, want) {
+		t.Errorf("CountNearbyDrivers = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,100 @@
+package lyft
+
+import (
+	"net/http"
+	"sync"
+)
+
+// unknownRemaining is the remaining requests for a credential without
+// a reported value. It is the maximum int.
+const unknownRemaining = int(^uint(0) >> 1)
+
+// Credential is an access token for a Lyft app.
+type Credential struct {
+	ClientID    string // The app's client ID. Used only to identify the credential.
+	AccessToken string
+}
+
+// CredentialPool distributes requests across the access tokens of several
+// Lyft apps, so that requests are spread across the apps' rate limits. Set
+// it in the Client's Credentials field. Use NewCredentialPool to create a
+// pool. A pool is safe for concurrent use.
+//
+// For each request, the pool picks the credential with the most requests
+// remaining, as reported by the X-Ratelimit-Remaining header in the
+// credential's latest response. Credentials without a reported value are
+// preferred, and ties are broken in round-robin order. If a response has
+// status code 401, the request is retried with each of the other credentials
+// in turn, until a response doesn't have status code 401.
+type CredentialPool struct {
+	mu        sync.Mutex
+	creds     []Credential
+	remaining []int // per credential
+	next      int   // index to start the round-robin search at
+}
+
+// NewCredentialPool returns a pool of the credentials. It panics if there
+// are no credentials.
+func NewCredentialPool(creds ...Credential) *CredentialPool {
+	if len(creds) == 0 {
+		panic("lyft: no credentials")
+	}
+	p := &CredentialPool{
+		creds:     append([]Credential(nil), creds...),
+		remaining: make([]int, len(creds)),
+	}
+	for i := range p.remaining {
+		p.remaining[i] = unknownRemaining
+	}
+	return p
+}
+
+// Credentials returns the pool's credentials.
+func (p *CredentialPool) Credentials() []Credential {
+	return append([]Credential(nil), p.creds...)
+}
+
+// pick returns the index of the credential to use for the next request.
+func (p *CredentialPool) pick() (int, Credential) {
+	i, cr, _ := p.pickExcept(nil)
+	return i, cr
+}
+
+// pickExcept is like pick, but it doesn't pick the credentials whose
+// indexes are in the exclude set. The ok result is false if all the
+// credentials are excluded.
+func (p *CredentialPool) pickExcept(exclude map[int]bool) (i int, cr Credential, ok bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	best := -1
+	for j := 0; j < len(p.creds); j++ {
+		i := (p.next + j) % len(p.creds)
+		if exclude[i] {
+			continue
+		}
+		if best == -1 || p.remaining[i] > p.remaining[best] {
+			best = i
+		}
+	}
+	if best == -1 {
+		return 0, Credential{}, false
+	}
+	p.next = (best + 1) % len(p.creds)
+	return best, p.creds[best], true
+}
+
+// observe records the rate limit headers of a response for the credential.
+// A credential whose response has status code 401 is treated as having no
+// requests remaining until its next response.
+func (p *CredentialPool) observe(i int, rsp *http.Response) {
+	n, ok := RateRemaining(rsp.Header)
+	if rsp.StatusCode == 401 {
+		n, ok = 0, true
+	}
+	if !ok {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.remaining[i] = n
+}
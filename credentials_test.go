@@ -0,0 +1,152 @@
+package lyft
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+var testCredentials = []Credential{
+	{ClientID: "a", AccessToken: "token-a"},
+	{ClientID: "b", AccessToken: "token-b"},
+	{ClientID: "c", AccessToken: "token-c"},
+}
+
+func TestCredentialPoolConcurrent(t *testing.T) {
+	const n = 60
+	var mu sync.Mutex
+	counts := make(map[string]int)
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		counts[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")]++
+		mu.Unlock()
+		w.Write([]byte(`{"ride_types": []}`))
+	})
+	c.Credentials = NewCredentialPool(testCredentials...)
+	used := make([]int, len(testCredentials))
+	c.OnCredential = func(i int) {
+		mu.Lock()
+		used[i]++
+		mu.Unlock()
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, _, err := c.RideTypes(37.7, -122.2, ""); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Without rate limit headers, the credentials are picked in
+	// round-robin order.
+	for i, cr := range testCredentials {
+		if counts[cr.AccessToken] != n/len(testCredentials) {
+			t.Errorf("requests with %s = %d, want %d", cr.ClientID, counts[cr.AccessToken], n/len(testCredentials))
+		}
+		if used[i] != n/len(testCredentials) {
+			t.Errorf("OnCredential(%d) calls = %d, want %d", i, used[i], n/len(testCredentials))
+		}
+	}
+}
+
+func TestCredentialPoolPrefersRemaining(t *testing.T) {
+	remaining := map[string]string{"token-a": "10", "token-b": "500", "token-c": "20"}
+	var got []string
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		tok := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		got = append(got, tok)
+		w.Header().Set("X-Ratelimit-Remaining", remaining[tok])
+		w.Header().Set("X-Ratelimit-Limit", "1000")
+		w.Write([]byte(`{"ride_types": []}`))
+	})
+	c.Credentials = NewCredentialPool(testCredentials...)
+
+	for i := 0; i < 5; i++ {
+		if _, _, err := c.RideTypes(37.7, -122.2, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The first three requests try each credential; after that, b has the
+	// most requests remaining.
+	want := []string{"token-a", "token-b", "token-c", "token-b", "token-b"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("requests = %q, want %q", got, want)
+			break
+		}
+	}
+}
+
+func TestCredentialPoolMaxTokenAttempts(t *testing.T) {
+	creds := []Credential{
+		{ClientID: "a", AccessToken: "expired-a"},
+		{ClientID: "b", AccessToken: "expired-b"},
+		{ClientID: "c", AccessToken: "expired-c"},
+		{ClientID: "d", AccessToken: "expired-d"},
+	}
+	tests := []struct {
+		max, want int
+	}{
+		{0, 4}, // all the credentials
+		{2, 2},
+		{1, 1},
+		{10, 4},
+	}
+	for _, tt := range tests {
+		var got []string
+		c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			got = append(got, r.Header.Get("Authorization"))
+			w.WriteHeader(401)
+			w.Write([]byte(`{"error": "token_expired"}`))
+		})
+		c.Credentials = NewCredentialPool(creds...)
+		c.MaxTokenAttempts = tt.max
+
+		_, _, err := c.RideTypes(37.7, -122.2, "")
+		se, ok := err.(*StatusError)
+		if !ok || se.StatusCode != 401 || se.Reason != TokenExpired {
+			t.Errorf("max %d: err = %v, want *StatusError with status code 401", tt.max, err)
+		}
+		if len(got) != tt.want {
+			t.Errorf("max %d: attempts = %d, want %d", tt.max, len(got), tt.want)
+		}
+		seen := make(map[string]bool)
+		for _, a := range got {
+			if seen[a] {
+				t.Errorf("max %d: credential %q tried twice", tt.max, a)
+			}
+			seen[a] = true
+		}
+	}
+}
+
+func TestCredentialPoolFailover(t *testing.T) {
+	var attempts int
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if r.Header.Get("Authorization") != "Bearer token-c" {
+			w.WriteHeader(401)
+			return
+		}
+		w.Write([]byte(`{"ride_types": []}`))
+	})
+	c.Credentials = NewCredentialPool(testCredentials...)
+	var used int
+	c.OnCredential = func(i int) { used = i }
+
+	if _, _, err := c.RideTypes(37.7, -122.2, ""); err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 3 {
+		t.Errorf("attempts = %d, want 3", attempts)
+	}
+	if used != 2 {
+		t.Errorf("OnCredential index = %d, want 2", used)
+	}
+}
@@ -0,0 +1,68 @@
+package lyft
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestValidCurrency(t *testing.T) {
+	tests := []struct {
+		code string
+		want bool
+	}{
+		{"USD", true},
+		{"CAD", true},
+		{"XYZ", false},
+		{"usd", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := ValidCurrency(tt.code); got != tt.want {
+			t.Errorf("ValidCurrency(%q) = %v, want %v", tt.code, got, tt.want)
+		}
+	}
+}
+
+func TestReportUnknownCurrencies(t *testing.T) {
+	const body = `{"cost_estimates": [
+		{"ride_type": "lyft", "currency": "USD", "estimated_cost_cents_min": 1000, "estimated_cost_cents_max": 1200, "is_valid_estimate": true},
+		{"ride_type": "lyft_plus", "currency": "XYZ", "estimated_cost_cents_min": 1500, "estimated_cost_cents_max": 1800, "is_valid_estimate": true}
+	]}`
+	for _, report := range []bool{false, true} {
+		var logs []string
+		c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(body))
+		})
+		c.ReportUnknownCurrencies = report
+		c.Logf = func(format string, args ...interface{}) {
+			logs = append(logs, fmt.Sprintf(format, args...))
+		}
+		estimates, _, err := c.CostEstimates(37.7, -122.2, IgnoreArg, IgnoreArg, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(estimates) != 2 || estimates[1].Currency != "XYZ" {
+			t.Errorf("report %v: estimates = %+v, want the XYZ estimate kept", report, estimates)
+		}
+		var want []string
+		if report {
+			want = []string{"lyft: unknown currencies in GET /v1/cost response: XYZ"}
+		}
+		if !reflect.DeepEqual(logs, want) {
+			t.Errorf("report %v: logs = %q, want %q", report, logs, want)
+		}
+	}
+}
+
+func TestUnknownCurrenciesReceipt(t *testing.T) {
+	r := RideReceipt{
+		Price:     Price{Money: Money{1000, "USD"}},
+		LineItems: []LineItem{{Money{800, "USD"}, "Base fare"}, {Money{200, "ABC"}, "Tip"}},
+		Charges:   []Charge{{Money: Money{1000, "XYZ"}}, {Money: Money{0, ""}}},
+	}
+	if got, want := unknownCurrencies(&r), []string{"ABC", "XYZ"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("unknownCurrencies = %q, want %q", got, want)
+	}
+}
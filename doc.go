@@ -23,10 +23,23 @@
 // unique Request-ID header set by Lyft. For details, see
 // https://developer.lyft.com/v1/docs/errors#section-detailed-information-on-error-codes.
 //
+// Contexts
+//
+// Every method that makes requests can be given a context.Context. Methods
+// added along with context support take the context as their first
+// argument; the older methods, such as RideTypes, have a variant with the
+// Context suffix, such as RideTypesContext, and use context.Background()
+// otherwise. If the context is done, the returned error wraps the context's
+// error, so errors.Is(err, context.Canceled) and
+// errors.Is(err, context.DeadlineExceeded) work as expected.
+//
 // Miscellaneous formats
 //
 // According to http://petstore.swagger.io/?url=https://api.lyft.com/v1/spec#/,
-// the format of the currency strings returned is ISO 4217.
+// the format of the currency strings returned is ISO 4217. Currency strings are
+// not validated when decoding by default; set the client's
+// ReportUnknownCurrencies field to log unknown codes, or use ValidCurrency to
+// check them.
 //
 // Usage
 //
@@ -34,7 +47,7 @@
 // ride types available at a location.
 //
 //   // Obtain an access token using the two-legged or three-legged flows.
-//   t, err := twoleg.GenerateToken(http.DefaultClient, lyft.BaseURL, os.Getenv("CLIENT_ID"), os.Getenv("CLIENT_SECRET"))
+//   t, _, err := twoleg.GenerateToken(http.DefaultClient, lyft.BaseURL, os.Getenv("CLIENT_ID"), os.Getenv("CLIENT_SECRET"))
 //   if err != nil {
 //       log.Fatalf("error generating token: %s", err)
 //   }
@@ -43,14 +56,15 @@
 //   c := lyft.NewClient(t.AccessToken)
 //
 //   // Make requests.
-//   r, header, err := c.RideTypes(37.7, -122.2)
+//   r, header, err := c.RideTypes(37.7, -122.2, "")
 //   if err != nil {
 //       log.Fatalf("error getting ride types: %s", err)
 //   }
 //   fmt.Printf("ride types: %+v\n", r)
 //   fmt.Printf("Request-ID: %s\n", lyft.RequestID(header))
 //
-// Missing Features
+// Sandbox
 //
-// The package does not yet support the sandbox-specific routes and the ride rating route.
+// The package supports Lyft's sandbox-specific routes; see
+// SetSandboxRideStatus, SetSandboxRideType, and SetSandboxPrimetime.
 package lyft
@@ -0,0 +1,87 @@
+package lyft
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// auxTypes maps types that implement json.Unmarshaler using an auxiliary
+// type to the auxiliary type, whose fields are the decoded JSON object's
+// fields.
+var auxTypes = map[reflect.Type]reflect.Type{
+	reflect.TypeOf(RideDetail{}):  reflect.TypeOf(rideDetail{}),
+	reflect.TypeOf(RideReceipt{}): reflect.TypeOf(rideReceipt{}),
+}
+
+// knownFields returns the lowercased names of the JSON object fields that
+// are decoded into a value of type t. The ok result is false if t isn't
+// a struct type.
+func knownFields(t reflect.Type) (names map[string]bool, ok bool) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if aux, ok := auxTypes[t]; ok {
+		t = aux
+	}
+	if t.Kind() != reflect.Struct {
+		return nil, false
+	}
+	names = make(map[string]bool)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" && f.Anonymous {
+			// Fields of embedded structs are promoted.
+			if embedded, ok := knownFields(f.Type); ok {
+				for n := range embedded {
+					names[n] = true
+				}
+				continue
+			}
+		}
+		if f.PkgPath != "" {
+			continue // unexported
+		}
+		if name == "" {
+			name = f.Name
+		}
+		// encoding/json matches field names case insensitively.
+		names[strings.ToLower(name)] = true
+	}
+	return names, true
+}
+
+// unknownFields returns the top-level fields of the JSON object in p that
+// aren't decoded into out, in sorted order.
+func unknownFields(p []byte, out interface{}) []string {
+	if !isJSONObject(p) {
+		return nil
+	}
+	known, ok := knownFields(reflect.TypeOf(out))
+	if !ok {
+		return nil
+	}
+	var m map[string]json.RawMessage
+	if err := JSONUnmarshal(p, &m); err != nil {
+		return nil
+	}
+	var unknown []string
+	for k := range m {
+		if !known[strings.ToLower(k)] {
+			unknown = append(unknown, k)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+func (c *Client) reportUnknownFields(method, path string, p []byte, out interface{}) {
+	if unknown := unknownFields(p, out); len(unknown) != 0 {
+		c.logf("lyft: unknown fields in %s %s response: %s", method, path, strings.Join(unknown, ", "))
+	}
+}
@@ -0,0 +1,57 @@
+package lyft
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestReportUnknownFields(t *testing.T) {
+	const body = `{"id": "1", "first_name": "Alice", "has_taken_a_ride": true, "referral_code": "ALICE1", "avatar": {}}`
+	for _, report := range []bool{false, true} {
+		var logs []string
+		c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(body))
+		})
+		c.ReportUnknownFields = report
+		c.Logf = func(format string, args ...interface{}) {
+			logs = append(logs, fmt.Sprintf(format, args...))
+		}
+		p, _, err := c.UserProfile()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.FirstName != "Alice" {
+			t.Errorf("report %v: UserProfile = %+v", report, p)
+		}
+		var want []string
+		if report {
+			want = []string{"lyft: unknown fields in GET /v1/profile response: avatar, referral_code"}
+		}
+		if !reflect.DeepEqual(logs, want) {
+			t.Errorf("report %v: logs = %q, want %q", report, logs, want)
+		}
+	}
+}
+
+func TestUnknownFields(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		out  interface{}
+		want []string
+	}{
+		{"aux type", `{"ride_id": "1", "status": "pending", "new_field": 1}`, &RideDetail{}, []string{"new_field"}},
+		{"embedded", `{"amount": 100, "currency": "USD", "payment_method": "card", "card_last4": "4242"}`, &Charge{}, []string{"card_last4"}},
+		{"case insensitive", `{"RIDE_ID": "1", "Status": "pending"}`, &RideDetail{}, nil},
+		{"skipped field", `{"amount": 100, "Valid": true}`, &Charge{}, []string{"Valid"}},
+		{"not an object", `[{"ride_id": "1"}]`, &[]RideDetail{}, nil},
+		{"not a struct", `{"a": 1}`, &map[string]int{}, nil},
+	}
+	for _, tt := range tests {
+		if got := unknownFields([]byte(tt.body), tt.out); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: unknownFields = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
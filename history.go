@@ -0,0 +1,252 @@
+package lyft
+
+import (
+	"context"
+	"encoding/csv"
+	"io"
+	"strconv"
+	"time"
+)
+
+// historyPager pages through the ride history between start and end.
+// Each page is requested with the maximum limit. The next page starts at the
+// latest requested time in the previous page, so it assumes that Lyft returns
+// the earliest rides in the range first. Rides requested in the same second
+// as the boundary may appear in both pages; they are returned only once.
+type historyPager struct {
+	c          *Client
+	start, end time.Time       // end may be zero
+	boundary   map[string]bool // IDs of the rides requested at start
+	done       bool
+}
+
+func (c *Client) newHistoryPager(start, end time.Time) *historyPager {
+	return &historyPager{c: c, start: start.Truncate(time.Second), end: end}
+}
+
+// next returns the next page of rides. It returns an empty page when
+// there are no more rides.
+func (p *historyPager) next(ctx context.Context) ([]RideDetail, error) {
+	for !p.done {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		rides, _, err := p.c.rideHistory(ctx, p.start, p.end, -1)
+		if err != nil {
+			return nil, err
+		}
+		if len(rides) < maxRideHistoryLimit {
+			p.done = true
+		}
+
+		latest := p.start
+		for _, r := range rides {
+			if t := r.Requested.Truncate(time.Second); t.After(latest) {
+				latest = t
+			}
+		}
+		var page []RideDetail
+		boundary := make(map[string]bool)
+		if latest.Equal(p.start) {
+			// The window didn't advance; keep the rides seen so far at
+			// the boundary.
+			for id := range p.boundary {
+				boundary[id] = true
+			}
+		}
+		for _, r := range rides {
+			if !p.boundary[r.RideID] {
+				page = append(page, r)
+			}
+			if r.Requested.Truncate(time.Second).Equal(latest) {
+				boundary[r.RideID] = true
+			}
+		}
+		page = DedupeRides(page)
+		if len(page) == 0 && latest.Equal(p.start) {
+			// No progress is possible, for example because more than
+			// the maximum limit of rides were requested in one second.
+			p.done = true
+		}
+		p.start, p.boundary = latest, boundary
+		if len(page) != 0 {
+			return page, nil
+		}
+	}
+	return nil, nil
+}
+
+// RideHistoryAll returns the authenticated user's rides between start and
+// end. If end is the zero time it is ignored. Unlike RideHistory, which
+// returns at most one page of rides, RideHistoryAll requests pages until
+// there are no more rides in the range. Rides requested in the same second
+// as the end of a page are returned once.
+//
+// If more rides were requested in one second than fit in a page, the rest
+// of the rides in that second are not returned. If the context is done or
+// a request fails, the rides fetched so far are returned along with the
+// error.
+func (c *Client) RideHistoryAll(ctx context.Context, start, end time.Time) ([]RideDetail, error) {
+	var ret []RideDetail
+	p := c.newHistoryPager(start, end)
+	for {
+		rides, err := p.next(ctx)
+		if err != nil {
+			return ret, contextError(ctx, err)
+		}
+		if len(rides) == 0 {
+			return ret, nil
+		}
+		ret = append(ret, rides...)
+	}
+}
+
+// RideHistoryIter iterates over the authenticated user's rides. Use the
+// client's RideHistoryIter method to create one.
+//
+//	it := c.RideHistoryIter(start, end)
+//	for it.Next(ctx) {
+//		r := it.Ride()
+//		// ...
+//	}
+//	if err := it.Err(); err != nil {
+//		// ...
+//	}
+type RideHistoryIter struct {
+	p    *historyPager
+	page []RideDetail
+	i    int // index of the current ride in page
+	err  error
+}
+
+// RideHistoryIter returns an iterator over the authenticated user's rides
+// between start and end. If end is the zero time it is ignored. The rides
+// are requested in pages, as needed, so memory use doesn't grow with the
+// number of rides. See RideHistoryAll for the rides that are returned.
+func (c *Client) RideHistoryIter(start, end time.Time) *RideHistoryIter {
+	return &RideHistoryIter{p: c.newHistoryPager(start, end)}
+}
+
+// Next advances the iterator to the next ride, requesting the next page of
+// rides with ctx if needed. It returns false when there are no more rides
+// or an error occurs; use Err to distinguish the two.
+func (it *RideHistoryIter) Next(ctx context.Context) bool {
+	if it.err != nil {
+		return false
+	}
+	if it.i+1 < len(it.page) {
+		it.i++
+		return true
+	}
+	page, err := it.p.next(ctx)
+	if err != nil {
+		it.err = contextError(ctx, err)
+		return false
+	}
+	it.page, it.i = page, 0
+	return len(page) != 0
+}
+
+// Ride returns the current ride. It must be called only after a call to
+// Next returns true.
+func (it *RideHistoryIter) Ride() RideDetail {
+	return it.page[it.i]
+}
+
+// Err returns the error, if any, that stopped the iteration.
+func (it *RideHistoryIter) Err() error {
+	return it.err
+}
+
+// statusRank orders ride statuses by progression, for DedupeRides.
+// Canceled rides rank with dropped off rides, since both are terminal.
+func statusRank(s string) int {
+	if s == StatusCanceled {
+		return len(rideLifecycle) - 1
+	}
+	return lifecycleIndex(s)
+}
+
+// DedupeRides returns the rides with duplicates, by RideID, removed. Of the
+// duplicates, the copy with the most advanced status in the ride's lifecycle
+// is kept; if the statuses are equally advanced, the copy with the later
+// requested time is kept, and then the later copy in the slice. The rides
+// are returned in the order of the first copy of each ride.
+func DedupeRides(rides []RideDetail) []RideDetail {
+	index := make(map[string]int) // ride ID to index in ret
+	var ret []RideDetail
+	for _, r := range rides {
+		i, ok := index[r.RideID]
+		if !ok {
+			index[r.RideID] = len(ret)
+			ret = append(ret, r)
+			continue
+		}
+		kept := &ret[i]
+		newRank, keptRank := statusRank(r.RideStatus), statusRank(kept.RideStatus)
+		if newRank > keptRank || (newRank == keptRank && !r.Requested.Before(kept.Requested)) {
+			*kept = r
+		}
+	}
+	return ret
+}
+
+// csvHeader is the header row written by StreamRidesCSV.
+var csvHeader = []string{
+	"ride_id", "status", "ride_type", "requested_at",
+	"origin_lat", "origin_lng", "origin_address",
+	"destination_lat", "destination_lng", "destination_address",
+	"distance_miles", "duration_seconds", "price_amount", "price_currency",
+}
+
+func rideCSVRecord(r *RideDetail) []string {
+	var requested string
+	if !r.Requested.IsZero() {
+		requested = r.Requested.Format(TimeLayout)
+	}
+	return []string{
+		r.RideID, r.RideStatus, r.RideType, requested,
+		formatFloat(r.Origin.Latitude), formatFloat(r.Origin.Longitude), r.Origin.Address,
+		formatFloat(r.Destination.Latitude), formatFloat(r.Destination.Longitude), r.Destination.Address,
+		formatFloat(r.Distance), strconv.FormatInt(int64(r.Duration/time.Second), 10),
+		strconv.Itoa(r.Price.Amount), r.Price.Currency,
+	}
+}
+
+// StreamRidesCSV writes the authenticated user's rides between start and end
+// to w in CSV format, with a header row. If end is the zero time it is
+// ignored. The rides are requested in pages and each page is written and
+// flushed before the next page is requested, so memory use doesn't grow with
+// the number of rides. Price amounts are in the currency's minor unit.
+//
+// If the context is done or a request fails, StreamRidesCSV stops and returns
+// the error; the rows written so far remain in w.
+func (c *Client) StreamRidesCSV(ctx context.Context, w io.Writer, start, end time.Time) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+
+	p := c.newHistoryPager(start, end)
+	for {
+		rides, err := p.next(ctx)
+		if err != nil {
+			cw.Flush()
+			return contextError(ctx, err)
+		}
+		if len(rides) == 0 {
+			break
+		}
+		for i := range rides {
+			if err := cw.Write(rideCSVRecord(&rides[i])); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,233 @@
+package lyft
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// historyServer serves the ride history of n rides, one a minute from
+// base, earliest first, honoring start_time, end_time, and limit. Rides 49
+// and 50 are requested in the same second, so they straddle the boundary
+// between the first two pages. The number of requests is stored in
+// requests.
+func historyServer(t *testing.T, base time.Time, n int, requests *int) *Client {
+	type ride struct {
+		id        string
+		requested time.Time
+	}
+	var rides []ride
+	for i := 0; i < n; i++ {
+		rides = append(rides, ride{strconv.Itoa(i), base.Add(time.Duration(i) * time.Minute)})
+	}
+	if n > 50 {
+		rides[50].requested = rides[49].requested
+	}
+	const layout = "2006-01-02T15:04:05Z"
+	return newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		*requests++
+		q := r.URL.Query()
+		start, err := time.Parse(layout, q.Get("start_time"))
+		if err != nil {
+			t.Error(err)
+		}
+		var end time.Time
+		if s := q.Get("end_time"); s != "" {
+			if end, err = time.Parse(layout, s); err != nil {
+				t.Error(err)
+			}
+		}
+		limit, _ := strconv.Atoi(q.Get("limit"))
+
+		var page []string
+		for _, rd := range rides {
+			if rd.requested.Before(start) || (!end.IsZero() && !rd.requested.Before(end)) {
+				continue
+			}
+			if len(page) == limit {
+				break
+			}
+			page = append(page, fmt.Sprintf(`{"ride_id": %q, "status": "droppedOff", "requested_at": %q}`,
+				rd.id, rd.requested.Format(time.RFC3339)))
+		}
+		fmt.Fprintf(w, `{"ride_history": [%s]}`, strings.Join(page, ","))
+	})
+}
+
+var historyBase = time.Date(2017, 11, 1, 8, 0, 0, 0, time.UTC)
+
+func TestRideHistoryIter(t *testing.T) {
+	var requests int
+	c := historyServer(t, historyBase, 120, &requests)
+
+	it := c.RideHistoryIter(historyBase, time.Time{})
+	var ids []int
+	for it.Next(bg) {
+		id, err := strconv.Atoi(it.Ride().RideID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 120 || !sort.IntsAreSorted(ids) {
+		t.Fatalf("rides = %v, want 0 through 119 in order", ids)
+	}
+	for i, id := range ids {
+		if id != i {
+			t.Fatalf("ride %d = %d, want %d", i, id, i)
+		}
+	}
+	// Pages start at 0, 49, and 98; the last page has 22 rides.
+	if requests != 3 {
+		t.Errorf("requests = %d, want 3", requests)
+	}
+	if it.Next(bg) {
+		t.Error("Next = true after the last ride")
+	}
+}
+
+func TestRideHistoryIterEnd(t *testing.T) {
+	var requests int
+	c := historyServer(t, historyBase, 120, &requests)
+
+	it := c.RideHistoryIter(historyBase, historyBase.Add(60*time.Minute))
+	var n int
+	for it.Next(bg) {
+		n++
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if n != 60 {
+		t.Errorf("rides = %d, want 60", n)
+	}
+}
+
+func TestRideHistoryIterCanceled(t *testing.T) {
+	var requests int
+	c := historyServer(t, historyBase, 120, &requests)
+
+	ctx, cancel := context.WithCancel(bg)
+	defer cancel()
+	it := c.RideHistoryIter(historyBase, time.Time{})
+	var n int
+	for it.Next(ctx) {
+		n++
+		if n == 50 {
+			cancel()
+		}
+	}
+	if !errors.Is(it.Err(), context.Canceled) {
+		t.Errorf("Err = %v, want context.Canceled", it.Err())
+	}
+	if n != 50 {
+		t.Errorf("rides = %d, want 50", n)
+	}
+	if requests != 1 {
+		t.Errorf("requests = %d, want 1", requests)
+	}
+}
+
+func TestRideHistoryAllPages(t *testing.T) {
+	var requests int
+	c := historyServer(t, historyBase, 120, &requests)
+	rides, err := c.RideHistoryAll(bg, historyBase, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rides) != 120 {
+		t.Errorf("rides = %d, want 120", len(rides))
+	}
+}
+
+func TestStreamRidesCSV(t *testing.T) {
+	var requests int
+	c := historyServer(t, historyBase, 120, &requests)
+	var buf bytes.Buffer
+	// Record how much had been written when each page was requested.
+	var written []int
+	transport := c.HTTPClient.Transport
+	c.HTTPClient.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		written = append(written, buf.Len())
+		return transport.RoundTrip(r)
+	})
+
+	if err := c.StreamRidesCSV(bg, &buf, historyBase, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 121 {
+		t.Fatalf("records = %d, want a header and 120 rides", len(records))
+	}
+	if !reflect.DeepEqual(records[0], csvHeader) {
+		t.Errorf("header = %q, want %q", records[0], csvHeader)
+	}
+	seen := make(map[string]bool)
+	for _, rec := range records[1:] {
+		if len(rec) != len(csvHeader) || rec[1] != StatusDroppedOff {
+			t.Errorf("record = %q", rec)
+		}
+		seen[rec[0]] = true
+	}
+	if len(seen) != 120 {
+		t.Errorf("distinct rides = %d, want 120", len(seen))
+	}
+	if len(written) < 3 {
+		t.Fatalf("requests = %d, want at least 3 pages", len(written))
+	}
+	for i := 1; i < len(written); i++ {
+		if written[i] <= written[i-1] {
+			t.Errorf("written before request %d = %d bytes, want more than before request %d (%d bytes)",
+				i, written[i], i-1, written[i-1])
+		}
+	}
+}
+
+func TestDedupeRides(t *testing.T) {
+	t0 := historyBase
+	ride := func(id, status string, requested time.Time, ridetype string) RideDetail {
+		return RideDetail{RideID: id, RideStatus: status, Requested: requested, RideType: ridetype}
+	}
+	rides := []RideDetail{
+		ride("1", StatusAccepted, t0, RideTypeLyft),
+		ride("2", StatusDroppedOff, t0, RideTypeLyft),
+		ride("1", StatusPickedUp, t0, RideTypeLyft),   // more advanced
+		ride("2", StatusDroppedOff, t0, RideTypeLyft), // exact duplicate
+		ride("3", StatusArrived, t0, RideTypeLyft),
+		ride("1", StatusPending, t0, RideTypeLyft),                  // less advanced
+		ride("3", StatusArrived, t0.Add(time.Second), RideTypePlus), // later request
+		ride("4", StatusCanceled, t0, RideTypeLyft),
+		ride("4", StatusPickedUp, t0, RideTypeLyft), // canceled is terminal
+		ride("5", StatusDroppedOff, t0, RideTypeLyft),
+		ride("5", StatusDroppedOff, t0, RideTypePlus), // later in the slice
+	}
+	want := []RideDetail{
+		ride("1", StatusPickedUp, t0, RideTypeLyft),
+		ride("2", StatusDroppedOff, t0, RideTypeLyft),
+		ride("3", StatusArrived, t0.Add(time.Second), RideTypePlus),
+		ride("4", StatusCanceled, t0, RideTypeLyft),
+		ride("5", StatusDroppedOff, t0, RideTypePlus),
+	}
+	got := DedupeRides(rides)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("DedupeRides = %+v, want %+v", got, want)
+	}
+	if got := DedupeRides(nil); len(got) != 0 {
+		t.Errorf("DedupeRides(nil) = %+v, want empty", got)
+	}
+}
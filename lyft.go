@@ -2,21 +2,31 @@ package lyft
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
+	"math"
 	"net/http"
 	"net/http/httputil"
+	"net/url"
 	"strconv"
 	"sync"
 	"time"
+
+	"github.com/nishanths/lyft-go/auth"
 )
 
 // BaseURL is the base URL for Lyft's HTTP API.
 const BaseURL = "https://api.lyft.com"
 
+// TimeLayout is the layout of the times in Lyft's responses. Parsed times
+// retain the offset present in the response. The requested and occurred
+// times may also be returned as seconds since the Unix epoch; such times
+// are decoded in UTC.
 const TimeLayout = time.RFC3339
 
 // Client is a client for the Lyft API. Use NewClient to create a client.
@@ -25,20 +35,134 @@ const TimeLayout = time.RFC3339
 type Client struct {
 	// The following fields are optional.
 	HTTPClient *http.Client // Uses http.DefaultClient if nil.
-	Header     http.Header  // Extra request headers to add.
+	Header     http.Header  // Extra request headers to add. Must not be modified after first use; see SetHeader.
 	BaseURL    string       // The base URL of the API; uses the package-level BaseURL if empty. Useful in tests.
 
-	mu          sync.Mutex // protects accessToken
+	// Timeouts for requests, applied as a deadline on each request's context.
+	// ReadTimeout applies to GET requests (such as the availability
+	// estimates) and WriteTimeout applies to the other requests (such as
+	// requesting or canceling a ride). Zero means no timeout.
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+
+	// MaxRetries is the maximum number of times a GET request is retried if
+	// the response has status code 429 or 5xx. Zero means no retries.
+	// Backoff determines the delay between attempts; DefaultBackoff is used
+	// if it is nil. The timeouts above apply to all the attempts together.
+	MaxRetries int
+	Backoff    Backoff
+
+	// OnRateLimit, if non-nil, is called after each response that has the
+	// X-Ratelimit-Remaining and X-Ratelimit-Limit headers, with the values of
+	// the headers. It can be used to slow down or alert before the rate limit
+	// is reached. It is called on the goroutine making the request, so it must
+	// be safe for concurrent use if the client is used concurrently.
+	OnRateLimit func(remaining, limit int)
+
+	// DefaultRideType is the ride type used by RequestRide, CostEstimates,
+	// and DriverETA when the ride type argument (or the RideRequest's
+	// RideType field) is empty. A non-empty ride type argument always
+	// takes precedence. If DefaultRideType is empty, the methods behave as
+	// documented for an empty ride type.
+	DefaultRideType string
+
+	// If PreflightRideTypes is true, RequestRide checks that the ride type
+	// is available at the origin, using RideTypes, before requesting the
+	// ride. If it isn't available, the error is a *RideTypeUnavailableError.
+	// The check costs an extra request, so it is off by default.
+	PreflightRideTypes bool
+
+	// Credentials, if non-nil, is used for the access token of each request
+	// instead of the client's access token. See CredentialPool.
+	Credentials *CredentialPool
+	// TokenSource, if non-nil, is used for the access token of each request
+	// instead of the client's access token. It is not used if Credentials
+	// is non-nil. See threeleg.NewRefreshingSource for a source that
+	// refreshes the access token before it expires.
+	TokenSource TokenSource
+	// OnCredential, if non-nil, is called with the index of the credential
+	// in Credentials that was used for each request whose response has a
+	// 2xx status code. It can be used to track the usage of each credential.
+	OnCredential func(index int)
+	// MaxTokenAttempts is the maximum number of credentials in Credentials
+	// that are tried for a request whose responses have status code 401.
+	// Zero means all the credentials. If every attempt fails, the error is
+	// the StatusError for the last response.
+	MaxTokenAttempts int
+
+	// Sandbox indicates that the client's access token is for Lyft's sandbox
+	// (see auth.SandboxSecret). If true, the Sandbox field of the rides
+	// and receipts returned by the client is set, so that sandbox data can
+	// be distinguished from real data. It does not change the requests.
+	Sandbox bool
+
+	// If ReportUnknownFields is true, the top-level fields in responses that
+	// the package doesn't decode are logged using Logf. It is useful for
+	// detecting changes to Lyft's API that the package should support.
+	// Nested objects are not checked.
+	ReportUnknownFields bool
+	// If ReportUnknownCurrencies is true, currency codes in responses that
+	// aren't known ISO 4217 codes (see ValidCurrency) are logged using Logf.
+	// The decoded values are not changed, so new codes are not rejected.
+	ReportUnknownCurrencies bool
+	// Logf is used to log messages, such as those for ReportUnknownFields.
+	// If nil, log.Printf is used.
+	Logf func(format string, args ...interface{})
+
+	mu          sync.Mutex // protects accessToken, scopes, and Header
 	accessToken string
+	scopes      []string // nil if unknown
+
+	cancelTokens    cancelTokenCache
+	idempotencyKeys idempotencyKeyCache
 
 	// Internal.
 	debug bool // Dump requests/responses using package log's default logger.
+	// Waits between retries and polls; the package-level sleep if nil. Set
+	// in tests.
+	sleep func(ctx context.Context, d time.Duration) error
 }
 
 func NewClient(accessToken string) *Client {
 	return &Client{accessToken: accessToken}
 }
 
+// ProxyHTTPClient returns an HTTP client, suitable for the HTTPClient field,
+// that sends requests through the proxy. Other than the proxy, the client's
+// transport is configured like http.DefaultTransport.
+//
+// Credentials for the proxy, if required, should be set in the URL's User
+// field; the transport sends them to the proxy in the Proxy-Authorization
+// header, and they are not sent to Lyft. Avoid logging the URL itself, since
+// url.URL's String method includes the password.
+func ProxyHTTPClient(proxy *url.URL) *http.Client {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Proxy = http.ProxyURL(proxy)
+	return &http.Client{Transport: t}
+}
+
+// TokenSource supplies access tokens for a Client. Token is called for each
+// request, so implementations that obtain tokens over the network should
+// cache them. Token must be safe for concurrent use. An error from Token is
+// returned as-is by the client's methods, and no request is made.
+//
+// If the TokenSource also has the method
+//
+//	Invalidate(token string)
+//
+// the client calls it with the access token of a request whose response
+// has status code 401, so that the source can drop the token from its cache
+// and obtain a new one for the next request. The request that got the 401
+// response is not retried.
+type TokenSource interface {
+	Token() (string, error)
+}
+
+// tokenInvalidator is implemented by TokenSources that cache tokens.
+type tokenInvalidator interface {
+	Invalidate(token string)
+}
+
 func (c *Client) AccessToken() string {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -51,6 +175,76 @@ func (c *Client) SetAccessToken(a string) {
 	c.accessToken = a
 }
 
+// Scopes returns the scopes granted to the client's access token, as set by
+// SetScopes. It returns nil if the scopes are unknown.
+func (c *Client) Scopes() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.scopes == nil {
+		return nil
+	}
+	return append([]string{}, c.scopes...)
+}
+
+// SetScopes sets the scopes granted to the client's access token, such as
+// the Scopes field of the token types in the auth subpackages. Call it
+// along with SetAccessToken when the token changes. A nil slice means that
+// the scopes are unknown.
+func (c *Client) SetScopes(scopes []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if scopes == nil {
+		c.scopes = nil
+		return
+	}
+	c.scopes = append([]string{}, scopes...)
+}
+
+// hasScope reports whether the access token has the scope. It returns true
+// if the scopes are unknown.
+func (c *Client) hasScope(scope string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.scopes == nil {
+		return true
+	}
+	for _, s := range c.scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// CanRequestRides reports whether the access token has the scope required
+// to request, update, and cancel rides. It returns true if the scopes are
+// unknown; see SetScopes.
+func (c *Client) CanRequestRides() bool { return c.hasScope(auth.RidesRequest) }
+
+// CanReadRides reports whether the access token has the scope required to
+// read ride details and history. It returns true if the scopes are unknown.
+func (c *Client) CanReadRides() bool { return c.hasScope(auth.RidesRead) }
+
+// CanReadProfile reports whether the access token has the scope required
+// to read the user's profile. It returns true if the scopes are unknown.
+func (c *Client) CanReadProfile() bool { return c.hasScope(auth.Profile) }
+
+func (c *Client) logf(format string, args ...interface{}) {
+	if c.Logf != nil {
+		c.Logf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
+
+// rideType returns t, or the client's DefaultRideType if t is empty.
+func (c *Client) rideType(t string) string {
+	if t == "" {
+		return c.DefaultRideType
+	}
+	return t
+}
+
 func (c *Client) base() string {
 	if c.BaseURL == "" {
 		return BaseURL
@@ -61,7 +255,25 @@ func (c *Client) base() string {
 func (c *Client) do(r *http.Request) (*http.Response, error) {
 	// Set up headers and add credentials.
 	c.addHeader(r.Header)
-	c.authorize(r.Header)
+	if id := RequestIDFromContext(r.Context()); id != "" {
+		r.Header.Set(ClientRequestIDHeader, id)
+	}
+	cred := -1        // index in c.Credentials
+	sourceToken := "" // from c.TokenSource
+	if c.Credentials != nil {
+		var cr Credential
+		cred, cr = c.Credentials.pick()
+		r.Header.Set("Authorization", "Bearer "+cr.AccessToken)
+	} else if c.TokenSource != nil {
+		t, err := c.TokenSource.Token()
+		if err != nil {
+			return nil, err
+		}
+		sourceToken = t
+		r.Header.Set("Authorization", "Bearer "+t)
+	} else {
+		c.authorize(r.Header)
+	}
 
 	// Determine the HTTP client to use.
 	client := http.DefaultClient
@@ -69,33 +281,326 @@ func (c *Client) do(r *http.Request) (*http.Response, error) {
 		client = c.HTTPClient
 	}
 
-	if c.debug {
-		dump, err := httputil.DumpRequestOut(r, true)
+	// Apply the timeout, if any. The context is canceled when the response
+	// body is closed.
+	cancel := func() {}
+	if t := c.timeout(r.Method); t > 0 {
+		var ctx context.Context
+		ctx, cancel = context.WithTimeout(r.Context(), t)
+		r = r.WithContext(ctx)
+	}
+
+	// Do the request, retrying if necessary.
+	var rsp *http.Response
+	var tried []int // credentials that have been tried
+	for attempt := 1; ; attempt++ {
+		if c.debug {
+			dump, err := dumpRequest(r)
+			if err != nil {
+				log.Printf("error dumping request: %s", err)
+			} else {
+				log.Printf("%s", dump)
+			}
+		}
+
+		var err error
+		rsp, err = client.Do(r)
 		if err != nil {
-			log.Printf("error dumping request: %s", err)
-		} else {
-			log.Printf("%s", dump)
+			cancel()
+			return nil, err
 		}
+
+		if c.debug {
+			dump, err := httputil.DumpResponse(rsp, true)
+			if err != nil {
+				log.Printf("error dumping response: %s", err)
+			} else {
+				log.Printf("%s", dump)
+			}
+		}
+
+		c.reportRateLimit(rsp.Header)
+		if rsp.StatusCode == 401 && c.TokenSource != nil && cred == -1 {
+			if inv, ok := c.TokenSource.(tokenInvalidator); ok {
+				inv.Invalidate(sourceToken)
+			}
+		}
+		if cred != -1 {
+			c.Credentials.observe(cred, rsp)
+			if rsp.StatusCode == 401 {
+				// Fail over to another credential. This doesn't count as
+				// a retry.
+				tried = append(tried, cred)
+				if c.MaxTokenAttempts > 0 && len(tried) >= c.MaxTokenAttempts {
+					break
+				}
+				if next, cr, ok := c.nextCredential(tried); ok && rewindBody(r) == nil {
+					drainAndClose(rsp.Body)
+					cred = next
+					r.Header.Set("Authorization", "Bearer "+cr.AccessToken)
+					attempt--
+					continue
+				}
+			}
+		}
+
+		if attempt > c.MaxRetries || !shouldRetry(r, rsp) {
+			break
+		}
+		d := c.backoff().NextDelay(attempt, rsp)
+		drainAndClose(rsp.Body)
+		if err := c.wait(r.Context(), d); err != nil {
+			cancel()
+			return nil, err
+		}
+	}
+
+	if cred != -1 && c.OnCredential != nil && rsp.StatusCode/100 == 2 {
+		c.OnCredential(cred)
+	}
+
+	rsp.Body = &cancelCloser{ReadCloser: rsp.Body, cancel: cancel}
+	return rsp, nil
+}
+
+// contextError returns err, wrapped so that errors.Is reports the context's
+// error, if the context is done and err doesn't already match it.
+func contextError(ctx context.Context, err error) error {
+	if err == nil || ctx.Err() == nil || errors.Is(err, ctx.Err()) {
+		return err
 	}
+	return fmt.Errorf("%w: %v", ctx.Err(), err)
+}
 
-	// Do the request.
-	rsp, err := client.Do(r)
+// nextCredential picks a credential from c.Credentials that isn't in tried.
+func (c *Client) nextCredential(tried []int) (int, Credential, bool) {
+	exclude := make(map[int]bool, len(tried))
+	for _, i := range tried {
+		exclude[i] = true
+	}
+	return c.Credentials.pickExcept(exclude)
+}
 
-	if c.debug {
-		dump, err := httputil.DumpResponse(rsp, true)
+// rewindBody resets the request's body, if any, so that the request can
+// be sent again.
+func rewindBody(r *http.Request) error {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil
+	}
+	if r.GetBody == nil {
+		return errors.New("request body cannot be rewound")
+	}
+	body, err := r.GetBody()
+	if err != nil {
+		return err
+	}
+	r.Body = body
+	return nil
+}
+
+func (c *Client) reportRateLimit(h http.Header) {
+	if c.OnRateLimit == nil {
+		return
+	}
+	remaining, ok := RateRemaining(h)
+	if !ok {
+		return
+	}
+	limit, ok := RateLimit(h)
+	if !ok {
+		return
+	}
+	c.OnRateLimit(remaining, limit)
+}
+
+// redactedHeaders are the request headers whose values are not included
+// in debug dumps.
+var redactedHeaders = []string{"Authorization", "Proxy-Authorization"}
+
+// dumpRequest is like httputil.DumpRequestOut, but the values of
+// redactedHeaders are replaced.
+func dumpRequest(r *http.Request) ([]byte, error) {
+	orig := r.Header
+	h := orig.Clone()
+	for _, k := range redactedHeaders {
+		if _, ok := h[k]; ok {
+			h.Set(k, "REDACTED")
+		}
+	}
+	r.Header = h
+	defer func() { r.Header = orig }()
+	return httputil.DumpRequestOut(r, true)
+}
+
+// JSONMarshal and JSONUnmarshal are the functions used to encode request
+// bodies and to decode responses, including in the UnmarshalJSON methods
+// of the package's types. They default to the functions in encoding/json,
+// and can be replaced with compatible functions from another JSON package.
+// Set them before using the package; they must not be modified concurrently
+// with their use. The subpackages always use encoding/json.
+var (
+	JSONMarshal   func(v interface{}) ([]byte, error)    = json.Marshal
+	JSONUnmarshal func(data []byte, v interface{}) error = json.Unmarshal
+)
+
+// request describes a request made using the client's send method.
+type request struct {
+	method  string
+	path    string      // Relative to the base URL.
+	query   url.Values  // Optional.
+	body    interface{} // Optional. Encoded as JSON.
+	header  http.Header // Optional. Added to the request's header.
+	success []int       // Status codes that indicate success. If empty, only 200 indicates success.
+	// If true, an empty response body for a successful response is not
+	// an error; out is left unmodified.
+	emptyOK bool
+	// If true, a successful response whose body is a Lyft error object is
+	// an error; see hasErrorBody.
+	checkErrorBody bool
+	// Optional. Returns the error for a response that didn't succeed.
+	// If nil, NewStatusError is used.
+	errorFunc func(*http.Response) error
+}
+
+func (req *request) succeeded(code int) bool {
+	if len(req.success) == 0 {
+		return code == 200
+	}
+	for _, s := range req.success {
+		if s == code {
+			return true
+		}
+	}
+	return false
+}
+
+// send makes the request with the supplied context. If the response
+// indicates success and out is non-nil, the response body is decoded into out.
+// The returned header is nil if and only if the HTTP roundtrip did not succeed.
+func (c *Client) send(ctx context.Context, req request, out interface{}) (http.Header, error) {
+	u := c.base() + req.path
+	if len(req.query) != 0 {
+		u += "?" + req.query.Encode()
+	}
+	var body io.Reader
+	if req.body != nil {
+		b, err := JSONMarshal(req.body)
 		if err != nil {
-			log.Printf("error dumping response: %s", err)
-		} else {
-			log.Printf("%s", dump)
+			return nil, err
 		}
+		body = bytes.NewReader(b)
+	}
+	r, err := http.NewRequestWithContext(ctx, req.method, u, body)
+	if err != nil {
+		return nil, err
 	}
+	if req.body != nil {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range req.header {
+		r.Header[k] = v
+	}
+
+	rsp, err := c.do(r)
+	if err != nil {
+		return nil, err
+	}
+	defer drainAndClose(rsp.Body)
+
+	if !req.succeeded(rsp.StatusCode) {
+		if req.errorFunc != nil {
+			return rsp.Header, req.errorFunc(rsp)
+		}
+		return rsp.Header, NewStatusError(rsp)
+	}
+	if out != nil {
+		b, err := ioutil.ReadAll(rsp.Body)
+		if err != nil {
+			return rsp.Header, err
+		}
+		if req.emptyOK && len(bytes.TrimSpace(b)) == 0 {
+			return rsp.Header, nil
+		}
+		if req.checkErrorBody && hasErrorBody(b) {
+			rsp.Body = ioutil.NopCloser(bytes.NewReader(b))
+			return rsp.Header, newStatusError(rsp)
+		}
+		if err := JSONUnmarshal(b, out); err != nil {
+			return rsp.Header, err
+		}
+		if c.ReportUnknownFields {
+			c.reportUnknownFields(req.method, req.path, b, out)
+		}
+		if c.ReportUnknownCurrencies {
+			c.reportUnknownCurrencies(req.method, req.path, out)
+		}
+	}
+	return rsp.Header, nil
+}
+
+// getJSON makes a GET request and decodes the successful response's body into out.
+func (c *Client) getJSON(ctx context.Context, path string, query url.Values, out interface{}) (http.Header, error) {
+	return c.send(ctx, request{method: "GET", path: path, query: query}, out)
+}
+
+// timeout returns the timeout to use for a request with the given method.
+func (c *Client) timeout(method string) time.Duration {
+	switch method {
+	case "GET", "HEAD":
+		return c.ReadTimeout
+	default:
+		return c.WriteTimeout
+	}
+}
+
+// cancelCloser calls cancel after closing the underlying ReadCloser.
+type cancelCloser struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
 
-	return rsp, err
+func (c *cancelCloser) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
+
+// SetHeader sets the extra request header key to value, replacing any
+// existing values. It is safe to call while requests are in progress.
+//
+// Once the client has been used, the Header field, and the map assigned to
+// it, must be modified only using SetHeader and DeleteHeader; modifying the
+// map directly races with requests that are in progress.
+func (c *Client) SetHeader(key, value string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// Copy, so that a map shared with other code isn't modified.
+	h := c.Header.Clone()
+	if h == nil {
+		h = make(http.Header)
+	}
+	h.Set(key, value)
+	c.Header = h
+}
+
+// DeleteHeader deletes the extra request header key. It is safe to call
+// while requests are in progress.
+func (c *Client) DeleteHeader(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	h := c.Header.Clone()
+	h.Del(key)
+	c.Header = h
 }
 
 // addHeader adds the key/values in c.Header to h.
 func (c *Client) addHeader(h http.Header) {
-	for key, values := range c.Header {
+	// SetHeader and DeleteHeader replace c.Header instead of modifying it,
+	// so the map can be read without holding the lock.
+	c.mu.Lock()
+	header := c.Header
+	c.mu.Unlock()
+	for key, values := range header {
 		for _, v := range values {
 			h.Add(key, v)
 		}
@@ -121,6 +626,7 @@ type ErrorInfo struct {
 	Reason      string
 	Details     []map[string]string
 	Description string
+	URI         string // A URL with more information on resolving the error, if provided
 }
 
 func newErrorInfo(body io.Reader, h http.Header) ErrorInfo {
@@ -137,18 +643,20 @@ func newErrorInfo(body io.Reader, h http.Header) ErrorInfo {
 		e = lyftErr.Slug
 	}
 
-	// The Details and Description fields.
+	// The Details, Description, and URI fields.
 	var det []map[string]string
-	var desc string
+	var desc, uri string
 	if decodeErr == nil {
 		det = lyftErr.Details
 		desc = lyftErr.Description
+		uri = lyftErr.URI
 	}
 
 	return ErrorInfo{
 		Reason:      e,
 		Details:     det,
 		Description: desc,
+		URI:         uri,
 	}
 }
 
@@ -157,10 +665,17 @@ var _ error = (*StatusError)(nil)
 // StatusError is returned when the HTTP roundtrip succeeded, but there
 // was error was indicated via the HTTP status code, typically due to an
 // application-level error.
+//
+// The response body is read in full when the StatusError is created, so
+// ResponseBody is a copy that remains valid after the response body is
+// closed. The caller that made the request remains responsible for closing
+// the response body.
 type StatusError struct {
 	StatusCode   int
+	StatusText   string // http.StatusText(StatusCode); may be empty for unknown codes
 	ResponseBody bytes.Buffer
-	ErrorInfo    // Fields may be empty
+	RetryAfter   time.Duration // From the Retry-After header; zero if absent
+	ErrorInfo                  // Fields may be empty
 }
 
 // NewStatusError is not meant for external use. It exists solely so that subpackages
@@ -179,25 +694,58 @@ func newStatusError(rsp *http.Response) *StatusError {
 	var buf bytes.Buffer // for the StatusError's ResponseBody field
 	buf.ReadFrom(rsp.Body)
 	buf2 := bytes.NewBuffer(buf.Bytes()) // another buffer for newErrorInfo to use.
+	retryAfter, _ := RetryAfter(rsp.Header)
 	return &StatusError{
 		StatusCode:   rsp.StatusCode,
+		StatusText:   http.StatusText(rsp.StatusCode),
 		ResponseBody: buf,
+		RetryAfter:   retryAfter,
 		ErrorInfo:    newErrorInfo(buf2, rsp.Header),
 	}
 }
 
 func (s *StatusError) Error() string {
+	status := fmt.Sprintf("status code=%d", s.StatusCode)
+	if s.StatusText != "" {
+		status += " (" + s.StatusText + ")"
+	}
 	if s.Reason != "" {
-		return fmt.Sprintf("%s: status code=%d", s.Reason, s.StatusCode)
+		return s.Reason + ": " + status
 	}
-	return fmt.Sprintf("status code=%d", s.StatusCode)
+	return status
 }
 
 // See https://developer.lyft.com/v1/docs/errors.
 type lyftError struct {
-	Slug        string              `json:"error"`
-	Details     []map[string]string `json:"error_detail"`
-	Description string              `json:"error_description"`
+	Slug        string       `json:"error"`
+	Details     errorDetails `json:"error_detail"`
+	Description string       `json:"error_description"`
+	URI         string       `json:"error_uri"`
+}
+
+// errorDetails is the type of the "error_detail" field, which may
+// be either an array of objects or a single object.
+type errorDetails []map[string]string
+
+func (e *errorDetails) UnmarshalJSON(p []byte) error {
+	if isJSONNull(p) {
+		*e = nil
+		return nil
+	}
+	if isJSONArray(p) {
+		var s []map[string]string
+		if err := JSONUnmarshal(p, &s); err != nil {
+			return err
+		}
+		*e = s
+		return nil
+	}
+	var m map[string]string
+	if err := JSONUnmarshal(p, &m); err != nil {
+		return err
+	}
+	*e = errorDetails{m}
+	return nil
 }
 
 // IsRateLimit returns whether the error arose because of running into a
@@ -209,6 +757,16 @@ func IsRateLimit(err error) bool {
 	return false
 }
 
+// IsPaymentRequired returns whether the error arose because of a problem
+// with the user's payment method (status code 402). The error's URI field,
+// if set, may link to where the user can fix the problem.
+func IsPaymentRequired(err error) bool {
+	if se, ok := err.(*StatusError); ok {
+		return se.StatusCode == 402
+	}
+	return false
+}
+
 // IsTokenExpired returns true if the error arose because the access token
 // expired.
 func IsTokenExpired(err error) bool {
@@ -225,6 +783,36 @@ func RequestID(h http.Header) string {
 	return h.Get("Request-ID")
 }
 
+// ClientRequestIDHeader is the request header used to send the request ID
+// set by WithRequestID.
+const ClientRequestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// WithRequestID returns a copy of ctx that carries a request ID, such as a
+// trace ID. Every request made with the context sends the ID in the
+// ClientRequestIDHeader header. All methods that make requests accept a
+// context, either directly or through their XxxContext variant; the
+// variants without a context never send an ID.
+//
+// Lyft does not document whether it records or echoes the header. To
+// correlate the two, log RequestIDFromContext(ctx) alongside RequestID of
+// the returned header:
+//
+//	ctx := lyft.WithRequestID(ctx, traceID)
+//	_, h, err := c.RideDetailContext(ctx, rideID)
+//	log.Printf("sent %s, lyft %s", lyft.RequestIDFromContext(ctx), lyft.RequestID(h))
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFromContext returns the request ID set on ctx by WithRequestID,
+// or "" if there is none.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
 // RateRemaining returns the value of X-Ratelimit-Remaining.
 func RateRemaining(h http.Header) (n int, ok bool) {
 	return intHeaderValue(h, "X-Ratelimit-Remaining")
@@ -235,6 +823,30 @@ func RateLimit(h http.Header) (n int, ok bool) {
 	return intHeaderValue(h, "X-Ratelimit-Limit")
 }
 
+// RetryAfter returns the value of Retry-After, which may be either
+// a number of seconds or an HTTP date, as a duration from now.
+func RetryAfter(h http.Header) (d time.Duration, ok bool) {
+	v := h.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Second * time.Duration(secs), true
+	}
+	t, err := http.ParseTime(v)
+	if err != nil {
+		return 0, false
+	}
+	d = time.Until(t)
+	if d < 0 {
+		d = 0
+	}
+	return d, true
+}
+
 func intHeaderValue(h http.Header, k string) (int, bool) {
 	vals, ok := h[k]
 	if !ok || len(vals) == 0 {
@@ -247,6 +859,81 @@ func intHeaderValue(h http.Header, k string) (int, bool) {
 	return i, true
 }
 
+// isJSONArray reports whether the JSON value in p is an array.
+func isJSONArray(p []byte) bool {
+	p = bytes.TrimLeft(p, " \t\r\n")
+	return len(p) != 0 && p[0] == '['
+}
+
+// hasErrorBody reports whether p is a JSON object with the "error" or
+// "error_description" keys, which Lyft sometimes responds with despite
+// a 200 status code.
+func hasErrorBody(p []byte) bool {
+	if !isJSONObject(p) {
+		return false
+	}
+	var m map[string]json.RawMessage
+	if err := JSONUnmarshal(p, &m); err != nil {
+		return false
+	}
+	_, ok1 := m["error"]
+	_, ok2 := m["error_description"]
+	return ok1 || ok2
+}
+
+// isJSONObject reports whether the JSON value in p is an object.
+func isJSONObject(p []byte) bool {
+	p = bytes.TrimLeft(p, " \t\r\n")
+	return len(p) != 0 && p[0] == '{'
+}
+
+// flexTime is a time that is decoded using DecodeTime.
+type flexTime struct {
+	time.Time
+}
+
+func (t *flexTime) UnmarshalJSON(p []byte) error {
+	parsed, err := DecodeTime(p)
+	if err != nil {
+		return err
+	}
+	t.Time = parsed
+	return nil
+}
+
+// DecodeTime decodes a time in a Lyft response, which may be represented
+// in JSON as either a string in TimeLayout or a number of seconds since the
+// Unix epoch. Empty strings and null decode to the zero time. Epoch times
+// are in UTC. It exists so that subpackages (such as package webhook) decode
+// times in the same way as this package.
+func DecodeTime(p []byte) (time.Time, error) {
+	p = bytes.TrimSpace(p)
+	if len(p) == 0 || isJSONNull(p) {
+		return time.Time{}, nil
+	}
+	if p[0] == '"' {
+		var s string
+		if err := JSONUnmarshal(p, &s); err != nil {
+			return time.Time{}, err
+		}
+		if s == "" {
+			return time.Time{}, nil
+		}
+		return time.Parse(TimeLayout, s)
+	}
+	secs, err := strconv.ParseFloat(string(p), 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %s", p)
+	}
+	whole, frac := math.Modf(secs)
+	return time.Unix(int64(whole), int64(frac*1e9)).UTC(), nil
+}
+
+// isJSONNull reports whether the JSON value in p is null.
+func isJSONNull(p []byte) bool {
+	return bytes.Equal(bytes.TrimSpace(p), []byte("null"))
+}
+
 func drainAndClose(r io.ReadCloser) {
 	io.Copy(ioutil.Discard, r)
 	r.Close()
@@ -257,5 +944,5 @@ func unmarshal(r io.Reader, v interface{}) error {
 	if err != nil {
 		return err
 	}
-	return json.Unmarshal(b, v)
+	return JSONUnmarshal(b, v)
 }
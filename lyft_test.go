@@ -0,0 +1,670 @@
+package lyft
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nishanths/lyft-go/auth"
+)
+
+var bg = context.Background()
+
+// newTestClient returns a client whose requests are handled by h.
+func newTestClient(t *testing.T, h http.HandlerFunc) *Client {
+	t.Helper()
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	c := NewClient("token")
+	c.BaseURL = srv.URL
+	c.HTTPClient = srv.Client()
+	return c
+}
+
+type testTokenSource struct {
+	mu          sync.Mutex
+	tokens      []string // returned in order; the last is repeated
+	invalidated []string
+}
+
+func (s *testTokenSource) Token() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t := s.tokens[0]
+	if len(s.tokens) > 1 {
+		s.tokens = s.tokens[1:]
+	}
+	return t, nil
+}
+
+func (s *testTokenSource) Invalidate(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.invalidated = append(s.invalidated, token)
+}
+
+func TestTokenSource(t *testing.T) {
+	var got []string
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		got = append(got, auth)
+		if auth == "Bearer expired" {
+			w.WriteHeader(401)
+			return
+		}
+		w.Write([]byte(`{"ride_types": []}`))
+	})
+	src := &testTokenSource{tokens: []string{"expired", "fresh"}}
+	c.TokenSource = src
+
+	if _, _, err := c.RideTypes(37.7, -122.2, ""); !IsTokenExpired(err) {
+		t.Fatalf("first request: err = %v, want token expired", err)
+	}
+	if _, _, err := c.RideTypes(37.7, -122.2, ""); err != nil {
+		t.Fatalf("second request: %v", err)
+	}
+
+	want := []string{"Bearer expired", "Bearer fresh"}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("Authorization headers = %q, want %q", got, want)
+	}
+	if len(src.invalidated) != 1 || src.invalidated[0] != "expired" {
+		t.Errorf("invalidated = %q, want [expired]", src.invalidated)
+	}
+}
+
+// TestSetHeaderConcurrent is meant to be run with the race detector.
+func TestSetHeaderConcurrent(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"ride_types": []}`))
+	})
+	c.Header = http.Header{"X-Initial": {"1"}}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				if _, _, err := c.RideTypes(37.7, -122.2, ""); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	for i := 0; i < 50; i++ {
+		c.SetHeader("X-Counter", strconv.Itoa(i))
+		c.DeleteHeader("X-Initial")
+	}
+	wg.Wait()
+}
+
+func TestSetHeader(t *testing.T) {
+	var got http.Header
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header
+		w.Write([]byte(`{"ride_types": []}`))
+	})
+	shared := http.Header{"X-Shared": {"1"}}
+	c.Header = shared
+	c.SetHeader("X-Added", "2")
+	c.DeleteHeader("X-Shared")
+
+	if _, _, err := c.RideTypes(37.7, -122.2, ""); err != nil {
+		t.Fatal(err)
+	}
+	if got.Get("X-Added") != "2" || got.Get("X-Shared") != "" {
+		t.Errorf("request header = %v, want X-Added and no X-Shared", got)
+	}
+	if shared.Get("X-Shared") != "1" || shared.Get("X-Added") != "" {
+		t.Errorf("shared map was modified: %v", shared)
+	}
+}
+
+func TestDecodeTime(t *testing.T) {
+	want := time.Date(2017, 11, 5, 17, 4, 51, 0, time.UTC)
+	tests := []struct {
+		in   string
+		want time.Time
+	}{
+		{`"2017-11-05T17:04:51Z"`, want},
+		{`"2017-11-05T09:04:51-08:00"`, want},
+		{`1509901491`, want},
+		{`1509901491.5`, want.Add(500 * time.Millisecond)},
+		{`""`, time.Time{}},
+		{`null`, time.Time{}},
+	}
+	for _, tt := range tests {
+		got, err := DecodeTime([]byte(tt.in))
+		if err != nil {
+			t.Errorf("DecodeTime(%s): %v", tt.in, err)
+			continue
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("DecodeTime(%s) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+	for _, in := range []string{`"yesterday"`, `true`} {
+		if _, err := DecodeTime([]byte(in)); err == nil {
+			t.Errorf("DecodeTime(%s): expected error", in)
+		}
+	}
+}
+
+func TestReceiptRequestedEpoch(t *testing.T) {
+	var r RideReceipt
+	if err := json.Unmarshal([]byte(`{"ride_id": "1", "requested_at": 1509901491}`), &r); err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2017, 11, 5, 17, 4, 51, 0, time.UTC); !r.Requested.Equal(want) {
+		t.Errorf("Requested = %v, want %v", r.Requested, want)
+	}
+}
+
+func TestContextErrors(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request to %s", r.URL.Path)
+	})
+	ctx, cancel := context.WithCancel(bg)
+	cancel()
+
+	calls := map[string]func() error{
+		"RideTypesContext":           func() error { _, _, err := c.RideTypesContext(ctx, 37.7, -122.2, ""); return err },
+		"RideDetailContext":          func() error { _, _, err := c.RideDetailContext(ctx, "1"); return err },
+		"UserProfileContext":         func() error { _, _, err := c.UserProfileContext(ctx); return err },
+		"RequestRideContext":         func() error { _, _, err := c.RequestRideContext(ctx, RideRequest{RideType: RideTypeLyft}); return err },
+		"RideHistoryAll":             func() error { _, err := c.RideHistoryAll(ctx, time.Now().Add(-time.Hour), time.Time{}); return err },
+		"SetSandboxPrimetimeContext": func() error { _, err := c.SetSandboxPrimetimeContext(ctx, 37.7, -122.2, "25%"); return err },
+	}
+	for name, call := range calls {
+		if err := call(); !errors.Is(err, context.Canceled) {
+			t.Errorf("%s: err = %v, want context.Canceled", name, err)
+		}
+	}
+}
+
+func TestContextErrorWrapsDeadline(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	})
+	ctx, cancel := context.WithTimeout(bg, 10*time.Millisecond)
+	defer cancel()
+	if _, _, err := c.CostEstimatesContext(ctx, 37.7, -122.2, IgnoreArg, IgnoreArg, ""); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestWithRequestID(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get(ClientRequestIDHeader); got != "trace-1" {
+			t.Errorf("%s = %q, want %q", ClientRequestIDHeader, got, "trace-1")
+		}
+		w.Header().Set("Request-ID", "lyft-1")
+		w.Write([]byte(`{"ride_id":"1"}`))
+	})
+	ctx := WithRequestID(bg, "trace-1")
+	_, h, err := c.RideDetailContext(ctx, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := RequestIDFromContext(ctx); got != "trace-1" {
+		t.Errorf("RequestIDFromContext = %q, want %q", got, "trace-1")
+	}
+	if got := RequestID(h); got != "lyft-1" {
+		t.Errorf("RequestID = %q, want %q", got, "lyft-1")
+	}
+}
+
+func TestWithoutRequestID(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Header[ClientRequestIDHeader]; ok {
+			t.Errorf("unexpected %s header", ClientRequestIDHeader)
+		}
+		w.Write([]byte(`{"ride_id":"1"}`))
+	})
+	if _, _, err := c.RideDetail("1"); err != nil {
+		t.Fatal(err)
+	}
+	if got := RequestIDFromContext(bg); got != "" {
+		t.Errorf("RequestIDFromContext = %q, want empty", got)
+	}
+}
+
+func TestStatusErrorMessage(t *testing.T) {
+	tests := []struct {
+		code int
+		body string
+		want string
+	}{
+		{429, "", "status code=429 (Too Many Requests)"},
+		{429, `{"error": "rate_limited"}`, "rate_limited: status code=429 (Too Many Requests)"},
+		{599, "", "status code=599"},
+	}
+	for _, tt := range tests {
+		c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tt.code)
+			w.Write([]byte(tt.body))
+		})
+		_, _, err := c.RideTypes(37.7, -122.2, "")
+		se, ok := err.(*StatusError)
+		if !ok {
+			t.Errorf("%d %s: err = %v, want *StatusError", tt.code, tt.body, err)
+			continue
+		}
+		if se.Error() != tt.want {
+			t.Errorf("%d %s: message = %q, want %q", tt.code, tt.body, se.Error(), tt.want)
+		}
+		if se.StatusText != http.StatusText(tt.code) {
+			t.Errorf("%d: StatusText = %q, want %q", tt.code, se.StatusText, http.StatusText(tt.code))
+		}
+	}
+}
+
+func TestJSONCodec(t *testing.T) {
+	origMarshal, origUnmarshal := JSONMarshal, JSONUnmarshal
+	defer func() { JSONMarshal, JSONUnmarshal = origMarshal, origUnmarshal }()
+
+	var mu sync.Mutex
+	var marshals, unmarshals int
+	JSONMarshal = func(v interface{}) ([]byte, error) {
+		mu.Lock()
+		marshals++
+		mu.Unlock()
+		return json.Marshal(v)
+	}
+	JSONUnmarshal = func(p []byte, v interface{}) error {
+		mu.Lock()
+		unmarshals++
+		mu.Unlock()
+		return json.Unmarshal(p, v)
+	}
+
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(costEstimatesBody))
+	})
+	estimates, _, err := c.CostEstimates(37.7763, -122.3918, 37.7972, -122.4533, RideTypePlus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(estimates) != 1 || estimates[0].Duration != 913*time.Second {
+		t.Errorf("estimates = %+v", estimates)
+	}
+	// One call for the response, and one for the estimate's UnmarshalJSON.
+	if unmarshals < 2 {
+		t.Errorf("JSONUnmarshal calls = %d, want at least 2", unmarshals)
+	}
+
+	c = newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(201)
+		w.Write([]byte(`{"ride_id": "1", "status": "pending"}`))
+	})
+	if _, _, err := c.RequestRide(RideRequest{RideType: RideTypeLyft}); err != nil {
+		t.Fatal(err)
+	}
+	if marshals != 1 {
+		t.Errorf("JSONMarshal calls = %d, want 1", marshals)
+	}
+}
+
+func BenchmarkCostEstimatesDecode(b *testing.B) {
+	p := []byte(costEstimatesBody)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(p)))
+	for i := 0; i < b.N; i++ {
+		var response struct {
+			E []CostEstimate `json:"cost_estimates"`
+		}
+		if err := JSONUnmarshal(p, &response); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// checkGoroutines fails the test if, after closing the client's idle
+// connections, there are more goroutines than before. Goroutines are given
+// some time to exit.
+func checkGoroutines(t *testing.T, c *Client, before int) {
+	t.Helper()
+	c.HTTPClient.CloseIdleConnections()
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Errorf("goroutines = %d, want at most %d", n, before)
+	}
+}
+
+// roundTripFunc is an http.RoundTripper implemented by a function.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+func TestTimeoutByMethod(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			w.WriteHeader(201)
+		}
+		w.Write([]byte(`{"ride_id": "1", "ride_types": []}`))
+	})
+	c.ReadTimeout = time.Hour
+	c.WriteTimeout = 2 * time.Hour
+	deadlines := make(map[string]time.Duration)
+	transport := c.HTTPClient.Transport
+	c.HTTPClient.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		if d, ok := r.Context().Deadline(); ok {
+			deadlines[r.Method] = time.Until(d)
+		}
+		return transport.RoundTrip(r)
+	})
+
+	if _, _, err := c.RideTypes(37.7, -122.2, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := c.RequestRide(testRideRequest); err != nil {
+		t.Fatal(err)
+	}
+	for method, want := range map[string]time.Duration{"GET": time.Hour, "POST": 2 * time.Hour} {
+		if got := deadlines[method]; got > want || got < want-time.Minute {
+			t.Errorf("%s deadline in %v, want about %v", method, got, want)
+		}
+	}
+}
+
+func TestReadTimeout(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		if r.Method == "POST" {
+			w.WriteHeader(201)
+		}
+		w.Write([]byte(`{"ride_id": "1", "ride_types": []}`))
+	})
+	c.ReadTimeout = 20 * time.Millisecond
+	c.WriteTimeout = 5 * time.Second
+
+	if _, _, err := c.RideTypes(37.7, -122.2, ""); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("GET: err = %v, want context.DeadlineExceeded", err)
+	}
+	if _, _, err := c.RequestRide(testRideRequest); err != nil {
+		t.Errorf("POST: %v", err)
+	}
+}
+
+func TestTimeoutCanceledOnClose(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	})
+	c.ReadTimeout = time.Hour
+	var ctx context.Context
+	transport := c.HTTPClient.Transport
+	c.HTTPClient.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		ctx = r.Context()
+		return transport.RoundTrip(r)
+	})
+
+	r, err := http.NewRequest("GET", c.BaseURL+"/v1/ridetypes", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsp, err := c.do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ctx.Err() != nil {
+		t.Fatal("context done before the body is closed")
+	}
+	rsp.Body.Close()
+	if ctx.Err() != context.Canceled {
+		t.Errorf("context error after Close = %v, want context.Canceled", ctx.Err())
+	}
+}
+
+func TestDebugRedactsCredentials(t *testing.T) {
+	var proxyAuth string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxyAuth = r.Header.Get("Proxy-Authorization")
+		w.Write([]byte(`{"ride_types": []}`))
+	}))
+	defer proxy.Close()
+	u, err := url.Parse(proxy.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.User = url.UserPassword("proxyuser", "proxysecret")
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	c := NewClient("bearersecret")
+	c.BaseURL = "http://api.lyft.invalid"
+	c.HTTPClient = ProxyHTTPClient(u)
+	c.debug = true
+	if _, _, err := c.RideTypes(37.7, -122.2, ""); err != nil {
+		t.Fatal(err)
+	}
+	// A Proxy-Authorization header set directly is redacted too.
+	c.SetHeader("Proxy-Authorization", "Basic headersecret")
+	if _, _, err := c.RideTypes(37.7, -122.2, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if proxyAuth == "" {
+		t.Error("request did not go through the proxy with credentials")
+	}
+	out := buf.String()
+	if !strings.Contains(out, "GET /v1/ridetypes") {
+		t.Errorf("debug output has no request dump:\n%s", out)
+	}
+	encoded := base64.StdEncoding.EncodeToString([]byte("proxyuser:proxysecret"))
+	for _, secret := range []string{"bearersecret", "proxysecret", encoded, "headersecret"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("debug output contains %q:\n%s", secret, out)
+		}
+	}
+	if !strings.Contains(out, "Authorization: REDACTED") {
+		t.Errorf("debug output has no redacted Authorization header:\n%s", out)
+	}
+}
+
+func TestErrorDetailsDecode(t *testing.T) {
+	tests := []struct {
+		body string
+		want errorDetails
+	}{
+		{`{"error": "bad_parameter", "error_detail": [{"lat": "invalid"}, {"lng": "invalid"}]}`,
+			errorDetails{{"lat": "invalid"}, {"lng": "invalid"}}},
+		{`{"error": "bad_parameter", "error_detail": {"lat": "invalid"}}`,
+			errorDetails{{"lat": "invalid"}}},
+		{`{"error": "bad_parameter", "error_detail": null}`, nil},
+		{`{"error": "bad_parameter"}`, nil},
+	}
+	for _, tt := range tests {
+		var e lyftError
+		if err := json.Unmarshal([]byte(tt.body), &e); err != nil {
+			t.Errorf("%s: %v", tt.body, err)
+			continue
+		}
+		if !reflect.DeepEqual(e.Details, tt.want) {
+			t.Errorf("%s: Details = %v, want %v", tt.body, e.Details, tt.want)
+		}
+	}
+}
+
+// trackingBody is a response body that records whether it was read to EOF
+// and closed.
+type trackingBody struct {
+	io.Reader
+	eof, closed bool
+}
+
+func (b *trackingBody) Read(p []byte) (int, error) {
+	n, err := b.Reader.Read(p)
+	if err == io.EOF {
+		b.eof = true
+	}
+	return n, err
+}
+
+func (b *trackingBody) Close() error {
+	b.closed = true
+	return nil
+}
+
+func TestSendClosesBody(t *testing.T) {
+	tests := []struct {
+		name   string
+		status []int // per attempt
+		body   string
+		ok     bool
+	}{
+		{"success", []int{200}, `{"ride_types": []}`, true},
+		{"error status", []int{404}, `{"error": "not_found"}`, false},
+		{"invalid body", []int{200}, `{"ride_types": `, false},
+		{"retried", []int{503, 503, 200}, `{"ride_types": []}`, true},
+	}
+	for _, tt := range tests {
+		var bodies []*trackingBody
+		c := NewClient("token")
+		c.MaxRetries = len(tt.status) - 1
+		c.sleep = func(context.Context, time.Duration) error { return nil }
+		c.HTTPClient = &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			b := &trackingBody{Reader: strings.NewReader(tt.body)}
+			bodies = append(bodies, b)
+			return &http.Response{
+				StatusCode: tt.status[len(bodies)-1],
+				Header:     http.Header{"Content-Type": {"application/json"}},
+				Body:       b,
+				Request:    r,
+			}, nil
+		})}
+
+		_, _, err := c.RideTypes(37.7, -122.2, "")
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: err = %v, want ok %v", tt.name, err, tt.ok)
+		}
+		if len(bodies) != len(tt.status) {
+			t.Errorf("%s: %d attempts, want %d", tt.name, len(bodies), len(tt.status))
+		}
+		for i, b := range bodies {
+			if !b.closed || !b.eof {
+				t.Errorf("%s: attempt %d: body closed = %v, read to EOF = %v, want true, true", tt.name, i, b.closed, b.eof)
+			}
+		}
+	}
+}
+
+func TestOnRateLimit(t *testing.T) {
+	headers := []map[string]string{
+		{"X-Ratelimit-Remaining": "41", "X-Ratelimit-Limit": "50"},
+		{"X-Ratelimit-Remaining": "0", "X-Ratelimit-Limit": "50"},
+		{"X-Ratelimit-Remaining": "40"},
+		{},
+	}
+	var n int
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		for k, v := range headers[n] {
+			w.Header().Set(k, v)
+		}
+		n++
+		w.Write([]byte(`{"ride_types": []}`))
+	})
+	var got [][2]int
+	c.OnRateLimit = func(remaining, limit int) {
+		got = append(got, [2]int{remaining, limit})
+	}
+	for range headers {
+		if _, _, err := c.RideTypes(37.7, -122.2, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if want := [][2]int{{41, 50}, {0, 50}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("OnRateLimit calls = %v, want %v", got, want)
+	}
+}
+
+func TestScopes(t *testing.T) {
+	tests := []struct {
+		scopes                                        []string
+		canRequestRides, canReadRides, canReadProfile bool
+	}{
+		{nil, true, true, true}, // unknown
+		{[]string{}, false, false, false},
+		{[]string{auth.Public}, false, false, false},
+		{[]string{auth.Public, auth.RidesRead}, false, true, false},
+		{[]string{auth.RidesRequest, auth.Profile, "unknown.scope"}, true, false, true},
+		{auth.AllScopes(), true, true, true},
+	}
+	for _, tt := range tests {
+		c := NewClient("token")
+		c.SetScopes(tt.scopes)
+		if got := c.CanRequestRides(); got != tt.canRequestRides {
+			t.Errorf("%q: CanRequestRides = %v, want %v", tt.scopes, got, tt.canRequestRides)
+		}
+		if got := c.CanReadRides(); got != tt.canReadRides {
+			t.Errorf("%q: CanReadRides = %v, want %v", tt.scopes, got, tt.canReadRides)
+		}
+		if got := c.CanReadProfile(); got != tt.canReadProfile {
+			t.Errorf("%q: CanReadProfile = %v, want %v", tt.scopes, got, tt.canReadProfile)
+		}
+		if got := c.Scopes(); (got == nil) != (tt.scopes == nil) || len(got) != len(tt.scopes) {
+			t.Errorf("%q: Scopes = %q", tt.scopes, got)
+		}
+	}
+}
+
+func TestCheckErrorBody(t *testing.T) {
+	const errorBody = `{"error": "no_service_in_area", "error_description": "Lyft is not available in this area"}`
+	tests := []struct {
+		body    string
+		wantErr bool
+		reason  string
+	}{
+		{errorBody, true, "no_service_in_area"},
+		{`{"error_description": "Lyft is not available in this area"}`, true, ""},
+		{`{"cost_estimates": [], "eta_estimates": []}`, false, ""},
+	}
+	for _, tt := range tests {
+		c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(tt.body))
+		})
+		_, _, costErr := c.CostEstimates(37.7, -122.2, IgnoreArg, IgnoreArg, "")
+		_, _, etaErr := c.DriverETA(37.7, -122.2, IgnoreArg, IgnoreArg, "")
+		for _, err := range []error{costErr, etaErr} {
+			if !tt.wantErr {
+				if err != nil {
+					t.Errorf("%s: %v", tt.body, err)
+				}
+				continue
+			}
+			serr, ok := err.(*StatusError)
+			if !ok || serr.StatusCode != 200 || serr.Reason != tt.reason {
+				t.Errorf("%s: err = %v (%T), want *StatusError with status 200 and reason %q", tt.body, err, err, tt.reason)
+			}
+		}
+	}
+
+	// Other requests decode an error-shaped body as usual.
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id": "1", "error": "ignored"}`))
+	})
+	if _, _, err := c.UserProfile(); err != nil {
+		t.Errorf("UserProfile: %v", err)
+	}
+}
@@ -0,0 +1,72 @@
+package lyft
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// Money is an amount of money in a currency. It is embedded in the types
+// that have an amount and a currency, such as Price and LineItem, so those
+// types also have Money's Float method. Since the String method would also
+// be promoted and hide the types' other fields when formatted, those types
+// define their own String methods.
+type Money struct {
+	Amount   int    `json:"amount"`   // In the currency's minor unit; for example, cents for USD.
+	Currency string `json:"currency"` // ISO 4217 currency code.
+}
+
+// minorDigits returns the number of digits in the currency's minor unit.
+// Unknown currencies are assumed to have two digits.
+func minorDigits(currency string) int {
+	if d, ok := iso4217[currency]; ok {
+		return d
+	}
+	return 2
+}
+
+// Float returns the amount in the currency's major unit; for example,
+// 1234 cents in USD is 12.34. Unknown currencies are assumed to have
+// two digits after the decimal separator.
+func (m Money) Float() float64 {
+	return float64(m.Amount) / math.Pow10(minorDigits(m.Currency))
+}
+
+// String returns the amount in the currency's major unit followed by the
+// currency code, such as "12.34 USD".
+func (m Money) String() string {
+	s := strconv.FormatFloat(m.Float(), 'f', minorDigits(m.Currency), 64)
+	if m.Currency == "" {
+		return s
+	}
+	return fmt.Sprintf("%s %s", s, m.Currency)
+}
+
+// ErrMixedCurrencies is returned when amounts in different currencies
+// would have to be combined.
+var ErrMixedCurrencies = errors.New("mixed currencies")
+
+// CurrencyConverter converts money to another currency, using exchange rates
+// supplied by the implementation. The package does not provide an implementation.
+//
+// Lyft always charges in the currency of the market of the ride, so converted
+// amounts are meant for display purposes only.
+type CurrencyConverter interface {
+	Convert(m Money, currency string) (Money, error)
+}
+
+// add adds the amount in the currency to m. A zero amount with an empty currency
+// is treated as missing and is ignored.
+func (m *Money) add(amount int, currency string) error {
+	if amount == 0 && currency == "" {
+		return nil
+	}
+	if m.Currency == "" {
+		m.Currency = currency
+	} else if currency != m.Currency {
+		return ErrMixedCurrencies
+	}
+	m.Amount += amount
+	return nil
+}
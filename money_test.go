@@ -0,0 +1,76 @@
+package lyft
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestMoneyString(t *testing.T) {
+	tests := []struct {
+		m    Money
+		want string
+	}{
+		{Money{1234, "USD"}, "12.34 USD"},
+		{Money{500, "JPY"}, "500 JPY"},
+		{Money{1234, "BHD"}, "1.234 BHD"},
+		{Money{-250, "USD"}, "-2.50 USD"},
+		{Money{1234, ""}, "12.34"},
+	}
+	for _, tt := range tests {
+		if got := tt.m.String(); got != tt.want {
+			t.Errorf("%#v.String() = %q, want %q", tt.m, got, tt.want)
+		}
+	}
+}
+
+// The receipt example from the Lyft API reference.
+const testReceipt = `{
+	"ride_id": "123456789",
+	"price": {"amount": 1360, "currency": "USD", "description": "Lyft fare"},
+	"line_items": [
+		{"amount": 1000, "currency": "USD", "type": "Lyft fare"},
+		{"amount": 360, "currency": "USD", "type": "Tip"}
+	],
+	"charges": [{"amount": 1360, "currency": "USD", "payment_method": "card"}],
+	"requested_at": "2017-11-05T17:04:51Z",
+	"ride_profile": "personal"
+}`
+
+func TestReceiptAmounts(t *testing.T) {
+	var r RideReceipt
+	if err := json.Unmarshal([]byte(testReceipt), &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Price.Amount != 1360 || r.Price.Currency != "USD" || !r.Price.Valid {
+		t.Errorf("price = %+v", r.Price)
+	}
+	if len(r.LineItems) != 2 || r.LineItems[0].Amount != 1000 || r.LineItems[1].Amount != 360 {
+		t.Errorf("line items = %+v", r.LineItems)
+	}
+	if len(r.Charges) != 1 || r.Charges[0].Amount != 1360 || !r.Charges[0].Valid {
+		t.Errorf("charges = %+v", r.Charges)
+	}
+}
+
+// Money's String method must not hide the other fields of the types that
+// embed it.
+func TestEmbeddedMoneyString(t *testing.T) {
+	tests := []struct {
+		v    fmt.Stringer
+		want string
+	}{
+		{Price{Money: Money{1360, "USD"}, Description: "Lyft fare", Valid: true}, "13.60 USD (Lyft fare)"},
+		{Price{Money: Money{0, "USD"}}, "0.00 USD [amount missing]"},
+		{LineItem{Money{360, "USD"}, "Tip"}, "Tip: 3.60 USD"},
+		{Charge{Money: Money{1360, "USD"}, PaymentMethod: "card", Valid: true}, "13.60 USD (card)"},
+		{CancellationPrice{Money{500, "USD"}, "abc", time.Minute}, "5.00 USD (token abc, valid for 1m0s)"},
+		{ChargeTotal{"card", Money{1360, "USD"}, 2}, "card: 13.60 USD (2 charges)"},
+	}
+	for _, tt := range tests {
+		if got := fmt.Sprint(tt.v); got != tt.want {
+			t.Errorf("fmt.Sprint(%T) = %q, want %q", tt.v, got, tt.want)
+		}
+	}
+}
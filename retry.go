@@ -0,0 +1,88 @@
+package lyft
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"time"
+)
+
+// Backoff determines how long to wait before retrying a request.
+// See the MaxRetries and Backoff fields of Client.
+type Backoff interface {
+	// NextDelay returns the delay before the specified retry attempt.
+	// attempt is 1 for the first retry, 2 for the second, and so on.
+	// rsp is the response that caused the retry; implementations may inspect
+	// its header (for instance, the Retry-After value) but must not read its body.
+	NextDelay(attempt int, rsp *http.Response) time.Duration
+}
+
+// DefaultBackoff is used if a Client's Backoff field is nil.
+var DefaultBackoff Backoff = ExponentialBackoff{Initial: time.Second, Max: 30 * time.Second}
+
+// ExponentialBackoff is a Backoff whose delay doubles after each attempt.
+type ExponentialBackoff struct {
+	Initial time.Duration // Delay before the first retry.
+	Max     time.Duration // Maximum delay. Zero means no maximum.
+}
+
+func (e ExponentialBackoff) NextDelay(attempt int, rsp *http.Response) time.Duration {
+	d := e.Initial
+	for i := 1; i < attempt; i++ {
+		if (e.Max > 0 && d >= e.Max) || d > math.MaxInt64/2 {
+			break
+		}
+		d *= 2
+	}
+	if e.Max > 0 && d > e.Max {
+		d = e.Max
+	}
+	return d
+}
+
+// ConstantBackoff is a Backoff with the same delay for every attempt.
+type ConstantBackoff time.Duration
+
+func (c ConstantBackoff) NextDelay(attempt int, rsp *http.Response) time.Duration {
+	return time.Duration(c)
+}
+
+func (c *Client) backoff() Backoff {
+	if c.Backoff == nil {
+		return DefaultBackoff
+	}
+	return c.Backoff
+}
+
+// wait is like sleep, but it uses the client's sleep field if it is set.
+func (c *Client) wait(ctx context.Context, d time.Duration) error {
+	if c.sleep == nil {
+		return sleep(ctx, d)
+	}
+	return c.sleep(ctx, d)
+}
+
+// shouldRetry returns whether the request should be retried given
+// the response. Only GET requests are retried, since the other requests
+// made by the client are not guaranteed to be idempotent.
+func shouldRetry(r *http.Request, rsp *http.Response) bool {
+	if r.Method != "GET" {
+		return false
+	}
+	return rsp.StatusCode == 429 || rsp.StatusCode >= 500
+}
+
+// sleep waits for the duration or until the context is done, whichever
+// happens first. It returns ctx.Err() if the context is done. The helpers
+// that poll use sleep between polls, so that they return promptly when the
+// context is done, and don't leave timers running.
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,90 @@
+package lyft
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// recordingBackoff records the attempt numbers it is called with and
+// returns a delay of attempt seconds.
+type recordingBackoff struct {
+	attempts []int
+}
+
+func (b *recordingBackoff) NextDelay(attempt int, rsp *http.Response) time.Duration {
+	b.attempts = append(b.attempts, attempt)
+	return time.Duration(attempt) * time.Second
+}
+
+func TestRetryBackoff(t *testing.T) {
+	var requests int
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests <= 3 {
+			w.WriteHeader(503)
+			return
+		}
+		w.Write([]byte(`{"ride_types": []}`))
+	})
+	b := &recordingBackoff{}
+	var delays []time.Duration
+	c.MaxRetries = 5
+	c.Backoff = b
+	c.sleep = func(ctx context.Context, d time.Duration) error {
+		delays = append(delays, d)
+		return nil
+	}
+
+	if _, _, err := c.RideTypes(37.7, -122.2, ""); err != nil {
+		t.Fatal(err)
+	}
+	if requests != 4 {
+		t.Errorf("requests = %d, want 4", requests)
+	}
+	if want := []int{1, 2, 3}; !reflect.DeepEqual(b.attempts, want) {
+		t.Errorf("attempts = %v, want %v", b.attempts, want)
+	}
+	if want := []time.Duration{time.Second, 2 * time.Second, 3 * time.Second}; !reflect.DeepEqual(delays, want) {
+		t.Errorf("delays = %v, want %v", delays, want)
+	}
+}
+
+func TestRetryMaxRetries(t *testing.T) {
+	var requests int
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(503)
+	})
+	c.MaxRetries = 2
+	c.Backoff = ConstantBackoff(time.Hour)
+	var delays []time.Duration
+	c.sleep = func(ctx context.Context, d time.Duration) error {
+		delays = append(delays, d)
+		return nil
+	}
+
+	if _, _, err := c.RideTypes(37.7, -122.2, ""); err == nil {
+		t.Fatal("expected error")
+	}
+	if requests != 3 {
+		t.Errorf("requests = %d, want 3", requests)
+	}
+	if want := []time.Duration{time.Hour, time.Hour}; !reflect.DeepEqual(delays, want) {
+		t.Errorf("delays = %v, want %v", delays, want)
+	}
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	b := ExponentialBackoff{Initial: time.Second, Max: 5 * time.Second}
+	var got []time.Duration
+	for attempt := 1; attempt <= 4; attempt++ {
+		got = append(got, b.NextDelay(attempt, nil))
+	}
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("delays = %v, want %v", got, want)
+	}
+}
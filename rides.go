@@ -2,13 +2,21 @@ package lyft
 
 import (
 	"bytes"
-	"encoding/json"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
+	"unicode"
 )
 
 // Ride types. May not be an exhaustive list.
@@ -43,10 +51,60 @@ func RideTypeDisplay(r string) string {
 type CostTokenInfo struct {
 	PrimetimePercentage string
 	PrimetimeMultiplier float64
-	PrimetimeToken      string
-	CostToken           string
-	TokenDuration       time.Duration
-	ErrorURI            string
+	// Deprecated: Lyft has replaced the primetime confirmation token
+	// with the cost token. Use Token instead.
+	PrimetimeToken string
+	CostToken      string
+	TokenDuration  time.Duration
+	ErrorURI       string
+}
+
+// PrimetimeFraction returns PrimetimePercentage as a fraction; for example,
+// "25%" is 0.25. It returns 0 if PrimetimePercentage is empty.
+func (c *CostTokenInfo) PrimetimeFraction() (float64, error) {
+	return parsePrimetimePercentage(c.PrimetimePercentage)
+}
+
+// parsePrimetimePercentage parses a primetime percentage, such as "25%",
+// into a fraction. The empty string means no primetime.
+func parsePrimetimePercentage(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(s, "%")), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid primetime percentage %q", s)
+	}
+	return n / 100, nil
+}
+
+// tokenDuration is a token duration in seconds. The API reference documents
+// it as a string, but a number is accepted too.
+type tokenDuration struct {
+	d     time.Duration
+	valid bool // false if the field is absent, null, or empty
+}
+
+func (t *tokenDuration) UnmarshalJSON(p []byte) error {
+	if isJSONNull(p) {
+		return nil
+	}
+	s := string(p)
+	if len(p) != 0 && p[0] == '"' {
+		if err := JSONUnmarshal(p, &s); err != nil {
+			return err
+		}
+		if s == "" {
+			return nil
+		}
+	}
+	i, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid token duration %s", p)
+	}
+	t.d, t.valid = time.Second*time.Duration(i), true
+	return nil
 }
 
 func newCostTokenInfo(body io.Reader) (CostTokenInfo, error) {
@@ -62,28 +120,45 @@ func (c *CostTokenInfo) UnmarshalJSON(p []byte) error {
 		CostToken           string  `json:"cost_token"`
 		// Is this seriously a string? Even Swagger says so.
 		// http://petstore.swagger.io/?url=https://api.lyft.com/v1/spec
-		TokenDuration string `json:"token_duration"` // in seconds
-		ErrorURI      string `json:"error_uri"`
+		TokenDuration tokenDuration `json:"token_duration"`
+		ErrorURI      string        `json:"error_uri"`
 	}
 	var aux costTokenInfo
-	if err := json.Unmarshal(p, &aux); err != nil {
+	if err := JSONUnmarshal(p, &aux); err != nil {
 		return err
 	}
 	c.PrimetimePercentage = aux.PrimetimePercentage
 	c.PrimetimeMultiplier = aux.PrimetimeMultiplier
 	c.PrimetimeToken = aux.PrimetimeToken
 	c.CostToken = aux.CostToken
-	i, err := strconv.ParseInt(aux.TokenDuration, 10, 64)
-	if err != nil {
-		return err
+	if !aux.TokenDuration.valid {
+		// Distinguishes cost details from other objects, such as the
+		// body of a RideRequestError that isn't about the cost.
+		return errors.New("missing token duration")
 	}
-	c.TokenDuration = time.Second * time.Duration(i)
+	c.TokenDuration = aux.TokenDuration.d
 	c.ErrorURI = aux.ErrorURI
 	return nil
 }
 
+// Token returns the token to use in a RideRequest's CostToken field to
+// confirm the cost. It is CostToken, or PrimetimeToken if Lyft only
+// returned the legacy token.
+func (c *CostTokenInfo) Token() string {
+	if c.CostToken != "" {
+		return c.CostToken
+	}
+	return c.PrimetimeToken
+}
+
 var _ error = (*RideRequestError)(nil)
 
+// Possible values for the Reason field in RideRequestError. May not be an
+// exhaustive list.
+const (
+	PrimetimeConfirmationRequired = "primetime_confirmation_required"
+)
+
 type RideRequestError struct {
 	ErrorInfo                // Fields may be empty
 	Cost      *CostTokenInfo // May be nil
@@ -121,8 +196,41 @@ func (c *RideRequestError) Error() string {
 type RideRequest struct {
 	Origin      Location `json:"origin"`      // Latitude and Longitude fields are required
 	Destination Location `json:"destination"` // Latitude and Longitude fields are required
-	RideType    string   `json:"ride_type"`   // Required
-	CostToken   string   `json:"cost_token"`  // Optional
+	RideType    string   `json:"ride_type"`   // Required, unless the client has a DefaultRideType
+	CostToken   string   `json:"cost_token"`  // Optional; see CostEstimate.Token and CostTokenInfo.Token
+	// Optional. Sent in the Idempotency-Key header. If empty, the client
+	// generates a key; see RequestRide.
+	IdempotencyKey string `json:"-"`
+	// Optional. When CostToken expires, if known; see CostEstimate.Cost.
+	// Not sent. RequestWithFreshCost obtains a new token if it has expired.
+	CostTokenExpires time.Time `json:"-"`
+}
+
+// DefaultIdempotencyKey returns a digest of the request's fields, other than
+// IdempotencyKey and CostTokenExpires, so that identical requests have the same digest.
+// Coordinates are compared exactly, and addresses and the ride type are
+// compared after trimming space; the ride type is also compared case
+// insensitively.
+//
+// The digest is not sent as is, since two legitimate identical requests,
+// such as the same commute on different days, would have the same key. The
+// client uses the digest to recognize identical requests; see RequestRide.
+func (r RideRequest) DefaultIdempotencyKey() string {
+	h := sha256.New()
+	for _, s := range []string{
+		formatFloat(r.Origin.Latitude),
+		formatFloat(r.Origin.Longitude),
+		strings.TrimSpace(r.Origin.Address),
+		formatFloat(r.Destination.Latitude),
+		formatFloat(r.Destination.Longitude),
+		strings.TrimSpace(r.Destination.Address),
+		strings.ToLower(strings.TrimSpace(r.RideType)),
+		r.CostToken,
+	} {
+		// Length-prefix each field so that the encoding is unambiguous.
+		fmt.Fprintf(h, "%d:%s;", len(s), s)
+	}
+	return hex.EncodeToString(h.Sum(nil))
 }
 
 // CreatedRide is returned by the client's RequestRide method.
@@ -133,6 +241,7 @@ type CreatedRide struct {
 	Origin      Location `json:"origin"`
 	Destination Location `json:"destination"`
 	Passenger   Person   `json:"passenger"` // The Phone field will not be set
+	Sandbox     bool     `json:"-"`         // Whether the ride was created by a sandbox client
 }
 
 type Location struct {
@@ -148,66 +257,321 @@ type Location struct {
 // If further action (such as confirming the cost) is required before the
 // ride can be successfully created, the error will be of type *RideRequestError.
 // This corresponds to the 400 status code documented in Lyft's API reference.
+// To confirm the cost, request the ride again with the CostToken field set to
+// the error's Cost.Token().
+//
+// If there is a problem with the user's payment method, the error will be a
+// *StatusError for which IsPaymentRequired returns true. See the client's
+// PreflightRideTypes field for checking the ride type before requesting.
+//
+// Both 201 (the documented status code) and 202 indicate success; Lyft
+// may respond with 202 if it accepts the request for asynchronous
+// processing. If the response has no body, the returned CreatedRide is
+// the zero value; use RideHistory to find the ride.
+//
+// The request is sent with an Idempotency-Key header: the request's
+// IdempotencyKey, or, if it is empty, a random key generated by the client.
+// The client reuses the key for identical requests (see
+// DefaultIdempotencyKey) made within 10 minutes of the first, so that a
+// retried request can be recognized as a duplicate; after that, an
+// identical request gets a new key. As of 2017-11-05, the header is not
+// documented in the Lyft API reference, so Lyft may not use it to detect
+// duplicate requests.
 func (c *Client) RequestRide(req RideRequest) (CreatedRide, http.Header, error) {
-	var buf bytes.Buffer
-	if err := json.NewEncoder(&buf).Encode(req); err != nil {
-		return CreatedRide{}, nil, err
+	return c.RequestRideContext(context.Background(), req)
+}
+
+// RequestRideContext is like RequestRide, but the request is made with
+// the supplied context. If the context is done, the error wraps the
+// context's error.
+func (c *Client) RequestRideContext(ctx context.Context, req RideRequest) (CreatedRide, http.Header, error) {
+	cr, h, err := c.requestRide(ctx, req)
+	return cr, h, contextError(ctx, err)
+}
+
+func (c *Client) requestRide(ctx context.Context, req RideRequest) (CreatedRide, http.Header, error) {
+	req.RideType = c.rideType(req.RideType)
+	if c.PreflightRideTypes {
+		if h, err := c.checkRideType(ctx, req); err != nil {
+			return CreatedRide{}, h, err
+		}
 	}
-	r, err := http.NewRequest("POST", c.base()+"/v1/rides", &buf)
+	key := req.IdempotencyKey
+	if key == "" {
+		key = c.idempotencyKeys.get(req.DefaultIdempotencyKey(), time.Now())
+	}
+	var cr CreatedRide
+	h, err := c.send(ctx, request{
+		method:  "POST",
+		path:    "/v1/rides",
+		body:    req,
+		header:  http.Header{"Idempotency-Key": {key}},
+		success: []int{201, 202},
+		emptyOK: true,
+		errorFunc: func(rsp *http.Response) error {
+			if rsp.StatusCode == 400 {
+				return newRideRequestError(rsp)
+			}
+			return NewStatusError(rsp)
+		},
+	}, &cr)
 	if err != nil {
-		return CreatedRide{}, nil, err
+		return CreatedRide{}, h, err
+	}
+	cr.Sandbox = c.Sandbox
+	return cr, h, nil
+}
+
+// idempotencyWindow is how long the client reuses the idempotency key it
+// generated for identical ride requests.
+const idempotencyWindow = 10 * time.Minute
+
+// idempotencyKeyCache holds the idempotency keys generated for ride
+// requests. The zero value is ready to use.
+type idempotencyKeyCache struct {
+	mu   sync.Mutex
+	keys map[string]idempotencyKey // by DefaultIdempotencyKey
+}
+
+type idempotencyKey struct {
+	key     string
+	expires time.Time
+}
+
+// get returns the key for the request with the digest, generating a new key
+// if there is no unexpired key for it. Expired keys are removed.
+func (k *idempotencyKeyCache) get(digest string, now time.Time) string {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for d, e := range k.keys {
+		if !now.Before(e.expires) {
+			delete(k.keys, d)
+		}
+	}
+	if e, ok := k.keys[digest]; ok {
+		return e.key
 	}
-	r.Header.Set("Content-Type", "application/json")
+	if k.keys == nil {
+		k.keys = make(map[string]idempotencyKey)
+	}
+	key := newIdempotencyKey(digest, now)
+	k.keys[digest] = idempotencyKey{key, now.Add(idempotencyWindow)}
+	return key
+}
 
-	rsp, err := c.do(r)
+func newIdempotencyKey(digest string, now time.Time) string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		// The time is unique enough within the window.
+		return fmt.Sprintf("%s-%d", digest, now.UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// RideTypeUnavailableError is returned by RequestRide if the client's
+// PreflightRideTypes field is true and the requested ride type isn't
+// available at the origin.
+type RideTypeUnavailableError struct {
+	RideType  string
+	Available []string // Ride types available at the origin.
+}
+
+func (e *RideTypeUnavailableError) Error() string {
+	return fmt.Sprintf("ride type %q unavailable; available: %s", e.RideType, strings.Join(e.Available, ", "))
+}
+
+// checkRideType checks that the request's ride type is available at the origin.
+func (c *Client) checkRideType(ctx context.Context, req RideRequest) (http.Header, error) {
+	types, h, err := c.rideTypes(ctx, req.Origin.Latitude, req.Origin.Longitude, "")
 	if err != nil {
-		return CreatedRide{}, nil, err
+		return h, err
 	}
-	defer drainAndClose(rsp.Body)
+	available := make([]string, 0, len(types))
+	for _, t := range types {
+		if !t.Available {
+			continue
+		}
+		if t.RideType == req.RideType {
+			return h, nil
+		}
+		available = append(available, t.RideType)
+	}
+	return h, &RideTypeUnavailableError{RideType: req.RideType, Available: available}
+}
 
-	switch rsp.StatusCode {
-	case 201:
-		var cr CreatedRide
-		if err := unmarshal(rsp.Body, &cr); err != nil {
-			return CreatedRide{}, rsp.Header, err
+// RequestWithFreshCost requests a ride, first obtaining a cost token for
+// the ride type from the cost estimates if req.CostToken is empty or
+// req.CostTokenExpires has passed. If Lyft responds that the cost must be
+// confirmed (for example, because primetime pricing changed), the estimate is
+// requested again and the ride is requested once more with the new token. The
+// ride is requested at most twice. Other errors are returned without retrying.
+//
+// Using the helper implies that the user accepts the current estimated cost.
+// The returned error is the same as RequestRide's.
+func (c *Client) RequestWithFreshCost(ctx context.Context, req RideRequest) (CreatedRide, http.Header, error) {
+	req.RideType = c.rideType(req.RideType)
+	if req.CostToken == "" || (!req.CostTokenExpires.IsZero() && !time.Now().Before(req.CostTokenExpires)) {
+		token, h, err := c.freshCostToken(ctx, req)
+		if err != nil {
+			return CreatedRide{}, h, contextError(ctx, err)
 		}
-		return cr, rsp.Header, nil
-	case 400:
-		return CreatedRide{}, rsp.Header, newRideRequestError(rsp)
-	default:
-		return CreatedRide{}, rsp.Header, NewStatusError(rsp)
+		req.CostToken = token
 	}
+
+	cr, h, err := c.requestRide(ctx, req)
+	if !needsCostConfirm(err) {
+		return cr, h, contextError(ctx, err)
+	}
+
+	token, h, err := c.freshCostToken(ctx, req)
+	if err != nil {
+		return CreatedRide{}, h, contextError(ctx, err)
+	}
+	req.CostToken = token
+	cr, h, err = c.requestRide(ctx, req)
+	return cr, h, contextError(ctx, err)
 }
 
-// SetDestination updates the ride's destination to the supplied location.
-// The location's Address field is optional.
-func (c *Client) SetDestination(rideID string, loc Location) (Location, http.Header, error) {
-	var buf bytes.Buffer
-	if err := json.NewEncoder(&buf).Encode(loc); err != nil {
-		return Location{}, nil, err
+// RequestRideWithCostConfirm requests a ride, and if Lyft responds that the
+// cost must be confirmed, requests the ride once more using the cost token
+// in the error's Cost field. Using it implies that the user accepts the
+// updated cost; to prompt the user instead, use RequestRide and handle the
+// *RideRequestError.
+//
+// Unlike RequestWithFreshCost, it doesn't request cost estimates. The returned
+// error is the same as RequestRide's.
+func (c *Client) RequestRideWithCostConfirm(ctx context.Context, req RideRequest) (CreatedRide, http.Header, error) {
+	cr, h, err := c.requestRide(ctx, req)
+	rerr, ok := err.(*RideRequestError)
+	if !ok || rerr.Cost == nil || rerr.Cost.Token() == "" {
+		return cr, h, contextError(ctx, err)
 	}
-	r, err := http.NewRequest("PUT", fmt.Sprintf("%s/v1/rides/%s/destination", c.base(), rideID), &buf)
+	req.CostToken = rerr.Cost.Token()
+	cr, h, err = c.requestRide(ctx, req)
+	return cr, h, contextError(ctx, err)
+}
+
+// needsCostConfirm reports whether err is a *RideRequestError asking for the
+// cost to be confirmed with a new cost token.
+func needsCostConfirm(err error) bool {
+	rerr, ok := err.(*RideRequestError)
+	return ok && (rerr.Cost != nil || rerr.Reason == PrimetimeConfirmationRequired)
+}
+
+// freshCostToken returns the cost token from the current estimate for the
+// request's ride type. The token is empty if the estimate has none.
+func (c *Client) freshCostToken(ctx context.Context, req RideRequest) (string, http.Header, error) {
+	estimates, h, err := c.costEstimates(ctx, req.Origin.Latitude, req.Origin.Longitude,
+		req.Destination.Latitude, req.Destination.Longitude, "")
 	if err != nil {
-		return Location{}, nil, err
+		return "", h, err
+	}
+	for i := range estimates {
+		if estimates[i].RideType == req.RideType {
+			return estimates[i].Token(), h, nil
+		}
 	}
-	r.Header.Set("Content-Type", "application/json")
+	return "", h, fmt.Errorf("no cost estimate for ride type %q", req.RideType)
+}
+
+// ErrRideCanceled is returned by RequestRideAndWait if the ride is canceled
+// before it reaches the awaited status.
+var ErrRideCanceled = errors.New("ride canceled")
 
-	rsp, err := c.do(r)
+// rideWaitInterval is the interval between polls in RequestRideAndWait.
+const rideWaitInterval = 5 * time.Second
+
+// RequestRideAndWait requests a ride and polls the ride's details until the
+// ride reaches the waitFor status or a later status in the ride's lifecycle.
+// For example, waiting for StatusAccepted returns the ride's details once a
+// driver accepts the ride, including the driver and vehicle. Use the context
+// to bound the wait.
+//
+// If the ride is canceled before reaching the status, the returned error
+// is ErrRideCanceled. If the context is done, the latest ride detail, if
+// any, is returned along with the context's error. The ride is not canceled
+// in either case. Errors from requesting the ride are the same as RequestRide's.
+func (c *Client) RequestRideAndWait(ctx context.Context, req RideRequest, waitFor string) (RideDetail, error) {
+	want := lifecycleIndex(waitFor)
+	if want == -1 {
+		return RideDetail{}, fmt.Errorf("invalid status to wait for %q", waitFor)
+	}
+	cr, _, err := c.requestRide(ctx, req)
 	if err != nil {
-		return Location{}, nil, err
+		return RideDetail{}, contextError(ctx, err)
+	}
+	if cr.RideID == "" {
+		return RideDetail{}, errors.New("ride request accepted without a ride ID")
 	}
-	defer drainAndClose(rsp.Body)
 
-	switch rsp.StatusCode {
-	case 200:
-		var ret Location
-		if err := unmarshal(rsp.Body, &ret); err != nil {
-			return Location{}, rsp.Header, err
+	var det RideDetail
+	for {
+		d, _, err := c.rideDetail(ctx, cr.RideID)
+		if err := ctx.Err(); err != nil {
+			return det, contextError(ctx, err)
+		}
+		if err != nil && !IsRateLimit(err) {
+			return det, contextError(ctx, err)
 		}
-		return ret, rsp.Header, nil
-	default:
-		return Location{}, rsp.Header, NewStatusError(rsp)
+		if err == nil {
+			det = d
+			if det.RideStatus == StatusCanceled {
+				return det, ErrRideCanceled
+			}
+			if lifecycleIndex(det.RideStatus) >= want {
+				return det, nil
+			}
+		}
+		if err := c.wait(ctx, rideWaitInterval); err != nil {
+			return det, contextError(ctx, err)
+		}
+	}
+}
+
+// ErrLineUnsupported is returned by methods that perform operations that
+// Lyft does not support for Lyft Line rides. Currently the only such method
+// is SetDestination.
+var ErrLineUnsupported = errors.New("operation not supported for Lyft Line rides")
+
+// SetDestination updates the ride's destination to the supplied location.
+// The location's Address field is optional.
+//
+// Lyft does not allow changing the destination of Lyft Line rides. If Lyft
+// rejects the update and the ride is a Lyft Line ride, the error is
+// ErrLineUnsupported.
+func (c *Client) SetDestination(rideID string, loc Location) (Location, http.Header, error) {
+	return c.SetDestinationContext(context.Background(), rideID, loc)
+}
+
+// SetDestinationContext is like SetDestination, but the request is made
+// with the supplied context. If the context is done, the error wraps the
+// context's error.
+func (c *Client) SetDestinationContext(ctx context.Context, rideID string, loc Location) (Location, http.Header, error) {
+	l, h, err := c.setDestination(ctx, rideID, loc)
+	return l, h, contextError(ctx, err)
+}
+
+func (c *Client) setDestination(ctx context.Context, rideID string, loc Location) (Location, http.Header, error) {
+	var ret Location
+	h, err := c.send(ctx, request{
+		method: "PUT",
+		path:   fmt.Sprintf("/v1/rides/%s/destination", rideID),
+		body:   loc,
+		errorFunc: func(rsp *http.Response) error {
+			statusErr := NewStatusError(rsp)
+			if rsp.StatusCode != 400 {
+				return statusErr
+			}
+			if det, _, err := c.rideDetail(ctx, rideID); err == nil && det.RideType == RideTypeLine {
+				return ErrLineUnsupported
+			}
+			return statusErr
+		},
+	}, &ret)
+	if err != nil {
+		return Location{}, h, err
 	}
+	return ret, h, nil
 }
 
 // RideReceipt is returned by the client's RideReceipt method.
@@ -218,64 +582,338 @@ type RideReceipt struct {
 	Charges     []Charge
 	Requested   time.Time
 	RideProfile string
+	Sandbox     bool // Whether the receipt was returned by a sandbox client.
+}
+
+// Auxiliary type for unmarshaling RideReceipt.
+type rideReceipt struct {
+	RideID      string     `json:"ride_id"`
+	Price       Price      `json:"price"`
+	LineItems   []LineItem `json:"line_items"`
+	Charges     []Charge   `json:"charges"`
+	Requested   flexTime   `json:"requested_at"`
+	RideProfile string     `json:"ride_profile"`
 }
 
 func (r *RideReceipt) UnmarshalJSON(p []byte) error {
-	type rideReceipt struct {
-		RideID      string     `json:"ride_id"`
-		Price       Price      `json:"price"`
-		LineItems   []LineItem `json:"line_items"`
-		Charges     []Charge   `json:"charges"`
-		Requested   string     `json:"requested_at"`
-		RideProfile string     `json:"ride_profile"`
-	}
 	var aux rideReceipt
-	if err := json.Unmarshal(p, &aux); err != nil {
+	if err := JSONUnmarshal(p, &aux); err != nil {
 		return err
 	}
 	r.RideID = aux.RideID
 	r.Price = aux.Price
 	r.LineItems = aux.LineItems
 	r.Charges = aux.Charges
-	if aux.Requested != "" {
-		requested, err := time.Parse(TimeLayout, aux.Requested)
-		if err != nil {
-			return err
-		}
-		r.Requested = requested
-	}
+	r.Requested = aux.Requested.Time
 	r.RideProfile = aux.RideProfile
 	return nil
 }
 
+// TotalsByCurrency returns the sum of the receipt's line items in each
+// currency, keyed by currency. Each line item is totaled in its own
+// currency, so receipts whose line items are in different currencies are
+// supported.
+func (r *RideReceipt) TotalsByCurrency() map[string]Money {
+	totals := make(map[string]Money)
+	for _, li := range r.LineItems {
+		m := totals[li.Currency]
+		m.Currency = li.Currency
+		m.Amount += li.Amount
+		totals[li.Currency] = m
+	}
+	return totals
+}
+
+// Total returns the sum of the receipt's charges, with refunds, which are
+// charges with negative amounts, subtracted. The charges are net of any
+// credits, so the total may be less than the sum of the line items (see
+// TotalsByCurrency). The error is ErrMixedCurrencies if the charges are not
+// all in the same currency; use ChargeTotalsByCurrency for receipts charged
+// in multiple currencies.
+func (r *RideReceipt) Total() (Money, error) {
+	var total Money
+	for _, ch := range r.Charges {
+		if err := total.add(ch.Amount, ch.Currency); err != nil {
+			return Money{}, err
+		}
+	}
+	return total, nil
+}
+
+// ChargeTotalsByCurrency returns the sum of the receipt's charges in each
+// currency, keyed by currency. Like Total, refunds are subtracted.
+func (r *RideReceipt) ChargeTotalsByCurrency() map[string]Money {
+	totals := make(map[string]Money)
+	for _, ch := range r.Charges {
+		m := totals[ch.Currency]
+		m.Currency = ch.Currency
+		m.Amount += ch.Amount
+		totals[ch.Currency] = m
+	}
+	return totals
+}
+
+// ChargesByPaymentMethod returns the sum of the receipt's charges for each
+// payment method, keyed by payment method. Like Total, refunds are
+// subtracted, and the error is ErrMixedCurrencies if the charges are not all
+// in the same currency; use ReconcileReceipts for totals by payment method
+// and currency.
+func (r *RideReceipt) ChargesByPaymentMethod() (map[string]Money, error) {
+	totals := make(map[string]Money)
+	var currency string
+	for _, ch := range r.Charges {
+		if currency == "" {
+			currency = ch.Currency
+		} else if ch.Currency != currency {
+			return nil, ErrMixedCurrencies
+		}
+		m := totals[ch.PaymentMethod]
+		m.Currency = ch.Currency
+		m.Amount += ch.Amount
+		totals[ch.PaymentMethod] = m
+	}
+	return totals, nil
+}
+
+// Line item categories, as returned by LineItem.Category.
+const (
+	CategoryFare  = "fare"
+	CategoryTip   = "tip"
+	CategoryTolls = "tolls"
+	CategoryFees  = "fees"
+	CategoryOther = "other"
+)
+
+// lineItemKeywords maps words in line item descriptions to categories. The
+// keywords are checked in order, so that, for example, "Toll fee" is
+// categorized as tolls; more specific keywords come before generic ones.
+var lineItemKeywords = []struct {
+	keyword, category string
+}{
+	{"tip", CategoryTip},
+	{"gratuity", CategoryTip},
+	{"toll", CategoryTolls},
+	{"fee", CategoryFees},
+	{"surcharge", CategoryFees},
+	{"tax", CategoryFees},
+	{"fare", CategoryFare},
+	{"base", CategoryFare},
+	{"distance", CategoryFare},
+	{"primetime", CategoryFare},
+	{"time", CategoryFare},
+	{"minimum", CategoryFare},
+	{"discount", CategoryFare},
+	{"credit", CategoryFare},
+}
+
+// Category returns the category of the line item based on its description:
+// one of CategoryFare, CategoryTip, CategoryTolls, CategoryFees, or
+// CategoryOther if the description is not recognized. Keywords match whole
+// words, or their plurals, so that "Taxi" is not categorized as a tax. Lyft
+// does not document the descriptions, so the categorization is best effort.
+func (li LineItem) Category() string {
+	words := strings.FieldsFunc(strings.ToLower(li.Description), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+	for _, k := range lineItemKeywords {
+		for _, w := range words {
+			if w == k.keyword || w == k.keyword+"s" || w == k.keyword+"es" {
+				return k.category
+			}
+		}
+	}
+	return CategoryOther
+}
+
+// categoryTotal returns the sum of the receipt's line items in the category.
+func (r *RideReceipt) categoryTotal(category string) (Money, error) {
+	var total Money
+	for _, li := range r.LineItems {
+		if li.Category() != category {
+			continue
+		}
+		if err := total.add(li.Amount, li.Currency); err != nil {
+			return Money{}, err
+		}
+	}
+	return total, nil
+}
+
+// Fare returns the sum of the receipt's line items in CategoryFare. See
+// LineItem.Category. The error is ErrMixedCurrencies if the line items
+// are not all in the same currency. Tip, Tolls, Fees, and Other are
+// similar.
+func (r *RideReceipt) Fare() (Money, error) { return r.categoryTotal(CategoryFare) }
+
+// Tip returns the sum of the receipt's line items in CategoryTip.
+func (r *RideReceipt) Tip() (Money, error) { return r.categoryTotal(CategoryTip) }
+
+// Tolls returns the sum of the receipt's line items in CategoryTolls.
+func (r *RideReceipt) Tolls() (Money, error) { return r.categoryTotal(CategoryTolls) }
+
+// Fees returns the sum of the receipt's line items in CategoryFees.
+func (r *RideReceipt) Fees() (Money, error) { return r.categoryTotal(CategoryFees) }
+
+// Other returns the sum of the receipt's line items in CategoryOther.
+func (r *RideReceipt) Other() (Money, error) { return r.categoryTotal(CategoryOther) }
+
 type Charge struct {
-	Amount        int    `json:"amount"`
-	Currency      string `json:"currency"`
+	Money
 	PaymentMethod string `json:"payment_method"`
+	// Valid is true if the response included the amount. It distinguishes
+	// a zero amount from a missing charge amount.
+	Valid bool `json:"-"`
+}
+
+// String returns the amount and the payment method, such as
+// "12.34 USD (card)". A missing amount (see Valid) is noted.
+func (c Charge) String() string {
+	s := c.Money.String()
+	if c.PaymentMethod != "" {
+		s += " (" + c.PaymentMethod + ")"
+	}
+	if !c.Valid {
+		s += " [amount missing]"
+	}
+	return s
+}
+
+func (c *Charge) UnmarshalJSON(b []byte) error {
+	type charge Charge // Prevents recursion.
+	var aux struct {
+		charge
+		Amount *int `json:"amount"`
+	}
+	if err := JSONUnmarshal(b, &aux); err != nil {
+		return err
+	}
+	*c = Charge(aux.charge)
+	if aux.Amount != nil {
+		c.Amount = *aux.Amount
+		c.Valid = true
+	}
+	return nil
 }
 
 // RideReceipt retrieves the receipt for the specified ride.
 func (c *Client) RideReceipt(rideID string) (RideReceipt, http.Header, error) {
-	r, err := http.NewRequest("GET", fmt.Sprintf("%s/v1/rides/%s/receipt", c.base(), rideID), nil)
+	return c.RideReceiptContext(context.Background(), rideID)
+}
+
+// RideReceiptContext is like RideReceipt, but the request is made with the
+// supplied context. If the context is done, the error wraps the context's
+// error.
+func (c *Client) RideReceiptContext(ctx context.Context, rideID string) (RideReceipt, http.Header, error) {
+	var rec RideReceipt
+	h, err := c.getJSON(ctx, fmt.Sprintf("/v1/rides/%s/receipt", rideID), nil, &rec)
 	if err != nil {
-		return RideReceipt{}, nil, err
+		return RideReceipt{}, h, contextError(ctx, err)
 	}
+	rec.Sandbox = c.Sandbox
+	return rec, h, nil
+}
 
-	rsp, err := c.do(r)
-	if err != nil {
-		return RideReceipt{}, nil, err
+// RealizedRates returns the rates actually paid for a ride: the receipt's
+// price divided by the ride's distance in miles and by its duration in minutes,
+// rounded to the nearest minor unit of the currency. These can be compared
+// against the ride type's Pricing. The receipt and the ride detail must be
+// for the same ride, and the receipt's price and the ride's distance and duration
+// must be set.
+func RealizedRates(rec *RideReceipt, ride *RideDetail) (perMile, perMinute Money, err error) {
+	switch {
+	case rec.RideID != ride.RideID:
+		return Money{}, Money{}, fmt.Errorf("receipt for ride %q does not match ride %q", rec.RideID, ride.RideID)
+	case rec.Price.Currency == "":
+		return Money{}, Money{}, errors.New("receipt has no price")
+	case ride.Distance <= 0:
+		return Money{}, Money{}, errors.New("ride has no distance")
+	case ride.Duration <= 0:
+		return Money{}, Money{}, errors.New("ride has no duration")
 	}
-	defer drainAndClose(rsp.Body)
+	amount := float64(rec.Price.Amount)
+	perMile = Money{Amount: int(math.Round(amount / ride.Distance)), Currency: rec.Price.Currency}
+	perMinute = Money{Amount: int(math.Round(amount / ride.Duration.Minutes())), Currency: rec.Price.Currency}
+	return perMile, perMinute, nil
+}
+
+// ReconcileReport is returned by ReconcileReceipts.
+type ReconcileReport struct {
+	Totals     []ChargeTotal     // Sorted by payment method, then currency.
+	Mismatches []ReceiptMismatch // In the order of the receipts.
+}
+
+// ChargeTotal is the total of the charges for a payment method in a currency.
+type ChargeTotal struct {
+	PaymentMethod string
+	Money
+	Count int // Number of charges.
+}
+
+// String returns the payment method, the total, and the number of charges,
+// such as "card: 12.34 USD (2 charges)".
+func (t ChargeTotal) String() string {
+	return fmt.Sprintf("%s: %s (%d charges)", t.PaymentMethod, t.Money, t.Count)
+}
 
-	if rsp.StatusCode != 200 {
-		return RideReceipt{}, rsp.Header, NewStatusError(rsp)
+// ReceiptMismatch describes a receipt whose price doesn't equal the sum
+// of its charges.
+type ReceiptMismatch struct {
+	RideID  string
+	Price   Price
+	Charged Money // Sum of the charges. Incomplete if Err is non-nil.
+	Err     error // ErrMixedCurrencies if the charges aren't in the price's currency.
+}
+
+// ReconcileReceipts totals the charges of the receipts by payment method and
+// currency, and reports the receipts whose price doesn't equal the sum of
+// their charges. Refunds, which are charges with negative amounts, are
+// subtracted. Receipts whose price is missing (see Price.Valid) are included
+// in the totals but are not checked for mismatches.
+func ReconcileReceipts(receipts []RideReceipt) ReconcileReport {
+	type key struct{ method, currency string }
+	totals := make(map[key]*ChargeTotal)
+	var report ReconcileReport
+
+	for _, rec := range receipts {
+		charged := Money{Currency: rec.Price.Currency}
+		var err error
+		for _, ch := range rec.Charges {
+			k := key{ch.PaymentMethod, ch.Currency}
+			t, ok := totals[k]
+			if !ok {
+				t = &ChargeTotal{PaymentMethod: ch.PaymentMethod, Money: Money{Currency: ch.Currency}}
+				totals[k] = t
+			}
+			t.Amount += ch.Amount
+			t.Count++
+			if err == nil {
+				err = charged.add(ch.Amount, ch.Currency)
+			}
+		}
+		if !rec.Price.Valid {
+			continue
+		}
+		if err != nil || charged.Amount != rec.Price.Amount {
+			report.Mismatches = append(report.Mismatches, ReceiptMismatch{
+				RideID:  rec.RideID,
+				Price:   rec.Price,
+				Charged: charged,
+				Err:     err,
+			})
+		}
 	}
 
-	var rec RideReceipt
-	if err := unmarshal(rsp.Body, &rec); err != nil {
-		return RideReceipt{}, rsp.Header, err
+	for _, t := range totals {
+		report.Totals = append(report.Totals, *t)
 	}
-	return rec, rsp.Header, nil
+	sort.Slice(report.Totals, func(i, j int) bool {
+		a, b := report.Totals[i], report.Totals[j]
+		if a.PaymentMethod != b.PaymentMethod {
+			return a.PaymentMethod < b.PaymentMethod
+		}
+		return a.Currency < b.Currency
+	})
+	return report
 }
 
 var _ error = (*CancelRideError)(nil)
@@ -332,57 +970,281 @@ func (c *CancelRideError) Error() string {
 // for more details on the token.
 //
 // If more action is required to cancel the ride, a returned error of
-// type *CancelRideError will have more details.
+// type *CancelRideError will have more details. See also ConfirmCancelRide.
 func (c *Client) CancelRide(rideID, cancelToken string) (http.Header, error) {
-	var body io.Reader
+	return c.CancelRideContext(context.Background(), rideID, cancelToken)
+}
+
+// CancelRideContext is like CancelRide, but the request is made with the
+// supplied context. If the context is done, the error wraps the context's
+// error.
+func (c *Client) CancelRideContext(ctx context.Context, rideID, cancelToken string) (http.Header, error) {
+	h, err := c.cancelRide(ctx, rideID, cancelToken)
+	return h, contextError(ctx, err)
+}
+
+func (c *Client) cancelRide(ctx context.Context, rideID, cancelToken string) (http.Header, error) {
+	req := request{
+		method:  "POST",
+		path:    fmt.Sprintf("/v1/rides/%s/cancel", rideID),
+		success: []int{204},
+		errorFunc: func(rsp *http.Response) error {
+			if rsp.StatusCode == 400 {
+				return newCancelRideError(rsp)
+			}
+			return NewStatusError(rsp)
+		},
+	}
 	if cancelToken != "" {
-		body = strings.NewReader(fmt.Sprintf(`{"cancel_confirmation_token": "%s"}`, cancelToken))
+		req.body = struct {
+			Token string `json:"cancel_confirmation_token"`
+		}{cancelToken}
 	}
-	r, err := http.NewRequest("POST", fmt.Sprintf("%s/v1/rides/%s/cancel", c.base(), rideID), body)
-	if err != nil {
-		return nil, err
+	h, err := c.send(ctx, req, nil)
+	if cerr, ok := err.(*CancelRideError); ok {
+		c.cancelTokens.put(rideID, cerr, time.Now())
+	} else if err == nil {
+		c.cancelTokens.delete(rideID)
 	}
-	if cancelToken != "" {
-		r.Header.Set("Content-Type", "application/json")
+	return h, err
+}
+
+// ConfirmCancelRide cancels the specified ride, confirming the cancellation
+// fee of the most recent *CancelRideError returned for the ride by the
+// Client. The error's token is reused until its TokenDuration has elapsed,
+// so that the caller can take time to confirm the fee.
+//
+// If there is no such token, or if it has expired, the ride is canceled
+// without a token; if a fee then has to be confirmed, the returned error
+// of type *CancelRideError has the new fee and token, and a subsequent call
+// confirms it.
+func (c *Client) ConfirmCancelRide(ctx context.Context, rideID string) (http.Header, error) {
+	token, _ := c.cancelTokens.get(rideID, time.Now())
+	h, err := c.cancelRide(ctx, rideID, token)
+	return h, contextError(ctx, err)
+}
+
+// cancelTokenCache holds the most recent cancellation token for rides.
+// The zero value is ready to use.
+type cancelTokenCache struct {
+	mu     sync.Mutex
+	tokens map[string]cancelToken // by ride ID
+}
+
+type cancelToken struct {
+	token   string
+	expires time.Time
+}
+
+func (t *cancelTokenCache) put(rideID string, e *CancelRideError, now time.Time) {
+	if e.Token == "" || e.TokenDuration <= 0 {
+		t.delete(rideID)
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.tokens == nil {
+		t.tokens = make(map[string]cancelToken)
 	}
+	t.tokens[rideID] = cancelToken{e.Token, now.Add(e.TokenDuration)}
+}
+
+// get returns the unexpired token for the ride, if any.
+func (t *cancelTokenCache) get(rideID string, now time.Time) (string, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tok, ok := t.tokens[rideID]
+	if !ok {
+		return "", false
+	}
+	if !now.Before(tok.expires) {
+		delete(t.tokens, rideID)
+		return "", false
+	}
+	return tok.token, true
+}
 
-	rsp, err := c.do(r)
+func (t *cancelTokenCache) delete(rideID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.tokens, rideID)
+}
+
+// activeRideWindow is how far back CancelActiveRides looks for active rides.
+const activeRideWindow = 24 * time.Hour
+
+// CancelActiveRidesError is returned by CancelActiveRides if some of
+// the active rides could not be canceled.
+type CancelActiveRidesError struct {
+	Errors map[string]error // Keyed by ride ID.
+}
+
+func (e *CancelActiveRidesError) Error() string {
+	return fmt.Sprintf("failed to cancel %d ride(s)", len(e.Errors))
+}
+
+// CancelActiveRides cancels the authenticated user's active rides, which are
+// the rides requested in the past day that have not been dropped off or
+// canceled. If canceling a ride requires confirming a cancellation fee, the fee
+// is confirmed automatically using the returned token. It returns the IDs of the
+// rides that were canceled.
+//
+// If some rides could not be canceled, the error is of type *CancelActiveRidesError.
+// If the context is done, the rides canceled so far are returned along with
+// the context's error.
+func (c *Client) CancelActiveRides(ctx context.Context) ([]string, error) {
+	rides, _, err := c.rideHistory(ctx, time.Now().Add(-activeRideWindow), time.Time{}, -1)
 	if err != nil {
-		return nil, err
+		return nil, contextError(ctx, err)
+	}
+
+	var canceled []string
+	errs := make(map[string]error)
+	for _, r := range rides {
+		switch r.RideStatus {
+		case StatusDroppedOff, StatusCanceled, StatusUnknown:
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return canceled, contextError(ctx, err)
+		}
+
+		_, err := c.cancelRide(ctx, r.RideID, "")
+		if cerr, ok := err.(*CancelRideError); ok && cerr.Token != "" {
+			_, err = c.cancelRide(ctx, r.RideID, cerr.Token)
+		}
+		if err != nil {
+			errs[r.RideID] = err
+			continue
+		}
+		canceled = append(canceled, r.RideID)
 	}
-	defer drainAndClose(rsp.Body)
 
-	switch rsp.StatusCode {
-	case 204:
-		return rsp.Header, nil
-	case 400:
-		return rsp.Header, newCancelRideError(rsp)
-	default:
-		return rsp.Header, NewStatusError(rsp)
+	if len(errs) != 0 {
+		return canceled, &CancelActiveRidesError{Errors: errs}
 	}
+	return canceled, nil
 }
 
-func (c *Client) RideDetail(rideID string) (RideDetail, http.Header, error) {
-	r, err := http.NewRequest("GET", fmt.Sprintf("%s/v1/rides/%s", c.base(), rideID), nil)
+// CancelRideFeePreview returns the fee that would be charged if the specified
+// ride were canceled now, without canceling the ride. The fee is determined
+// from the ride detail's cancellation price. If canceling is free, the returned
+// CancellationPrice is the zero value. If the context is done, the error
+// wraps the context's error.
+func (c *Client) CancelRideFeePreview(ctx context.Context, rideID string) (CancellationPrice, http.Header, error) {
+	det, h, err := c.rideDetail(ctx, rideID)
 	if err != nil {
-		return RideDetail{}, nil, err
+		return CancellationPrice{}, h, contextError(ctx, err)
 	}
+	if det.CancellationPrice.Amount == 0 {
+		return CancellationPrice{}, h, nil
+	}
+	return det.CancellationPrice, h, nil
+}
 
-	rsp, err := c.do(r)
+func (c *Client) RideDetail(rideID string) (RideDetail, http.Header, error) {
+	return c.RideDetailContext(context.Background(), rideID)
+}
+
+// RideDetailContext is like RideDetail, but the request is made with the
+// supplied context. If the context is done, the error wraps the context's
+// error.
+func (c *Client) RideDetailContext(ctx context.Context, rideID string) (RideDetail, http.Header, error) {
+	det, h, err := c.rideDetail(ctx, rideID)
+	return det, h, contextError(ctx, err)
+}
+
+func (c *Client) rideDetail(ctx context.Context, rideID string) (RideDetail, http.Header, error) {
+	var det RideDetail
+	h, err := c.getJSON(ctx, fmt.Sprintf("/v1/rides/%s", rideID), nil, &det)
 	if err != nil {
-		return RideDetail{}, nil, err
+		return RideDetail{}, h, err
 	}
-	defer drainAndClose(rsp.Body)
+	det.Sandbox = c.Sandbox
+	return det, h, nil
+}
+
+// ErrNotShareable is returned by ShareLink if the ride can't be shared,
+// because it has ended or Lyft didn't return a link for it.
+var ErrNotShareable = errors.New("ride is not shareable")
 
-	if rsp.StatusCode != 200 {
-		return RideDetail{}, rsp.Header, NewStatusError(rsp)
+// ShareLink returns a URL for tracking the ride that can be shared with
+// others, such as to share the ride's ETA. The URL is the ride's RouteURL.
+// The error is ErrNotShareable if the ride has been dropped off or canceled,
+// or if the ride detail has no route URL.
+//
+// Lyft does not document how long the link remains valid; it should be
+// treated as valid only while the ride is active.
+func (c *Client) ShareLink(ctx context.Context, rideID string) (string, error) {
+	det, _, err := c.rideDetail(ctx, rideID)
+	if err != nil {
+		return "", contextError(ctx, err)
+	}
+	switch det.RideStatus {
+	case StatusDroppedOff, StatusCanceled:
+		return "", ErrNotShareable
 	}
+	if det.RouteURL == "" {
+		return "", ErrNotShareable
+	}
+	return det.RouteURL, nil
+}
 
-	var det RideDetail
-	if err := unmarshal(rsp.Body, &det); err != nil {
-		return RideDetail{}, rsp.Header, err
+// RideProgress is returned by the client's RideProgress method.
+type RideProgress struct {
+	RideID     string          `json:"ride_id"`
+	RideStatus string          `json:"status"`
+	Location   VehicleLocation `json:"location"`
+}
+
+// RideProgress is like RideDetail, but only decodes the ride's ID, status,
+// and vehicle location. It is useful when frequently polling a ride.
+//
+// Lyft's API does not support requesting a subset of fields, so the full
+// ride detail is transferred; the other fields are skipped while decoding,
+// which is cheaper than decoding a RideDetail.
+func (c *Client) RideProgress(rideID string) (RideProgress, http.Header, error) {
+	return c.RideProgressContext(context.Background(), rideID)
+}
+
+// RideProgressContext is like RideProgress, but the request is made with the
+// supplied context. If the context is done, the error wraps the context's
+// error.
+func (c *Client) RideProgressContext(ctx context.Context, rideID string) (RideProgress, http.Header, error) {
+	var p RideProgress
+	h, err := c.getJSON(ctx, fmt.Sprintf("/v1/rides/%s", rideID), nil, &p)
+	if err != nil {
+		return RideProgress{}, h, contextError(ctx, err)
 	}
-	return det, rsp.Header, nil
+	return p, h, nil
+}
+
+// RateRide submits the passenger's rating of the ride's driver, and optional
+// feedback. The rating must be between 1 and 5. Empty feedback is omitted
+// from the request.
+func (c *Client) RateRide(rideID string, rating int, feedback string) (http.Header, error) {
+	return c.RateRideContext(context.Background(), rideID, rating, feedback)
 }
 
-// TODO: Implement this: func (c *Client) RateRide()
+// RateRideContext is like RateRide, but the request is made with the
+// supplied context. If the context is done, the error wraps the context's
+// error.
+func (c *Client) RateRideContext(ctx context.Context, rideID string, rating int, feedback string) (http.Header, error) {
+	h, err := c.rateRide(ctx, rideID, rating, feedback)
+	return h, contextError(ctx, err)
+}
+
+func (c *Client) rateRide(ctx context.Context, rideID string, rating int, feedback string) (http.Header, error) {
+	if rating < 1 || rating > 5 {
+		return nil, fmt.Errorf("invalid rating %d: must be between 1 and 5", rating)
+	}
+	return c.send(ctx, request{
+		method: "PUT",
+		path:   fmt.Sprintf("/v1/rides/%s/rating", rideID),
+		body: struct {
+			Rating   int    `json:"rating"`
+			Feedback string `json:"feedback,omitempty"`
+		}{rating, feedback},
+		success: []int{204},
+	}, nil)
+}
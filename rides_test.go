@@ -0,0 +1,974 @@
+package lyft
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testRideRequest = RideRequest{
+	Origin:      Location{Latitude: 37.77, Longitude: -122.41, Address: "1 Market St"},
+	Destination: Location{Latitude: 37.79, Longitude: -122.39},
+	RideType:    RideTypeLyft,
+}
+
+func TestDefaultIdempotencyKey(t *testing.T) {
+	same := testRideRequest
+	same.RideType = " LYFT "
+	same.IdempotencyKey = "ignored"
+	if testRideRequest.DefaultIdempotencyKey() != same.DefaultIdempotencyKey() {
+		t.Error("identical requests have different digests")
+	}
+
+	for name, modify := range map[string]func(*RideRequest){
+		"origin":      func(r *RideRequest) { r.Origin.Latitude += 0.0001 },
+		"address":     func(r *RideRequest) { r.Origin.Address = "2 Market St" },
+		"destination": func(r *RideRequest) { r.Destination.Longitude += 0.0001 },
+		"ride type":   func(r *RideRequest) { r.RideType = RideTypePlus },
+		"cost token":  func(r *RideRequest) { r.CostToken = "token" },
+	} {
+		other := testRideRequest
+		modify(&other)
+		if testRideRequest.DefaultIdempotencyKey() == other.DefaultIdempotencyKey() {
+			t.Errorf("requests with different %s have the same digest", name)
+		}
+	}
+}
+
+func TestIdempotencyKeyCache(t *testing.T) {
+	var k idempotencyKeyCache
+	now := time.Now()
+
+	first := k.get("a", now)
+	if got := k.get("a", now.Add(idempotencyWindow-time.Second)); got != first {
+		t.Errorf("key within the window = %q, want %q", got, first)
+	}
+	if k.get("b", now) == first {
+		t.Error("different requests have the same key")
+	}
+	if got := k.get("a", now.Add(idempotencyWindow)); got == first {
+		t.Error("key after the window was reused")
+	}
+	if first == "a" {
+		t.Error("key is the digest")
+	}
+}
+
+func TestRequestRideIdempotencyKey(t *testing.T) {
+	var keys []string
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		keys = append(keys, r.Header.Get("Idempotency-Key"))
+		w.WriteHeader(201)
+		w.Write([]byte(`{"ride_id": "1", "status": "pending"}`))
+	})
+
+	for i := 0; i < 2; i++ {
+		if _, _, err := c.RequestRide(testRideRequest); err != nil {
+			t.Fatal(err)
+		}
+	}
+	explicit := testRideRequest
+	explicit.IdempotencyKey = "mine"
+	if _, _, err := c.RequestRide(explicit); err != nil {
+		t.Fatal(err)
+	}
+
+	if keys[0] == "" || keys[0] != keys[1] {
+		t.Errorf("keys for identical requests = %q, %q; want equal and non-empty", keys[0], keys[1])
+	}
+	if keys[2] != "mine" {
+		t.Errorf("explicit key = %q, want mine", keys[2])
+	}
+}
+
+func TestCancelRideFeePreview(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want CancellationPrice
+	}{
+		{"free", `{"ride_id": "1", "status": "accepted"}`, CancellationPrice{}},
+		{"free with zero amount", `{"ride_id": "1", "status": "accepted", "cancellation_price": {"amount": 0, "currency": "USD"}}`, CancellationPrice{}},
+		{"fee", `{"ride_id": "1", "status": "arrived", "cancellation_price": {"amount": 500, "currency": "USD", "token": "abc", "token_duration": 60}}`,
+			CancellationPrice{Money{500, "USD"}, "abc", time.Minute}},
+	}
+	for _, tt := range tests {
+		var canceled bool
+		c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != "GET" {
+				canceled = true
+			}
+			w.Write([]byte(tt.body))
+		})
+		got, _, err := c.CancelRideFeePreview(bg, "1")
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: fee = %+v, want %+v", tt.name, got, tt.want)
+		}
+		if canceled {
+			t.Errorf("%s: ride was canceled", tt.name)
+		}
+	}
+}
+
+// freshCostServer serves cost estimates with tokens "t1", "t2", ... and
+// responds to the first surge ride requests with a 400 asking for the cost
+// to be confirmed. It records the cost token of each ride request.
+func freshCostServer(t *testing.T, surge int, body string) (*Client, *[]string) {
+	var estimates int
+	var tokens []string
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/cost":
+			estimates++
+			w.Write([]byte(`{"cost_estimates": [{"ride_type": "lyft", "cost_token": "t` + strconv.Itoa(estimates) + `", "is_valid_estimate": true}]}`))
+		case "/v1/rides":
+			var req RideRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				t.Error(err)
+			}
+			tokens = append(tokens, req.CostToken)
+			if len(tokens) <= surge {
+				w.WriteHeader(400)
+				w.Write([]byte(body))
+				return
+			}
+			w.WriteHeader(201)
+			w.Write([]byte(`{"ride_id": "1", "status": "pending"}`))
+		default:
+			t.Errorf("unexpected request to %s", r.URL.Path)
+		}
+	})
+	return c, &tokens
+}
+
+const surgeBody = `{"error": "primetime_confirmation_required", "error_description": "confirm", "primetime_percentage": "25%", "cost_token": "surge", "token_duration": "60"}`
+
+func TestRequestWithFreshCost(t *testing.T) {
+	tests := []struct {
+		name   string
+		surge  int
+		body   string
+		modify func(*RideRequest)
+		want   []string // cost tokens sent
+		err    bool
+	}{
+		{"no surge", 0, "", nil, []string{"t1"}, false},
+		{"surge", 1, surgeBody, nil, []string{"t1", "t2"}, false},
+		{"surge reason only", 1, `{"error": "primetime_confirmation_required"}`, nil, []string{"t1", "t2"}, false},
+		{"surge twice", 2, surgeBody, nil, []string{"t1", "t2"}, true},
+		{"other error", 1, `{"error": "bad_parameter"}`, nil, []string{"t1"}, true},
+		{"caller token", 0, "", func(r *RideRequest) { r.CostToken = "mine" }, []string{"mine"}, false},
+		{"unexpired caller token", 0, "", func(r *RideRequest) {
+			r.CostToken = "mine"
+			r.CostTokenExpires = time.Now().Add(time.Minute)
+		}, []string{"mine"}, false},
+		{"expired caller token", 0, "", func(r *RideRequest) {
+			r.CostToken = "mine"
+			r.CostTokenExpires = time.Now().Add(-time.Second)
+		}, []string{"t1"}, false},
+	}
+	for _, tt := range tests {
+		c, tokens := freshCostServer(t, tt.surge, tt.body)
+		req := testRideRequest
+		if tt.modify != nil {
+			tt.modify(&req)
+		}
+		cr, _, err := c.RequestWithFreshCost(bg, req)
+		if tt.err {
+			if _, ok := err.(*RideRequestError); !ok {
+				t.Errorf("%s: err = %v, want *RideRequestError", tt.name, err)
+			}
+		} else if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		} else if cr.RideID != "1" {
+			t.Errorf("%s: ride ID = %q, want 1", tt.name, cr.RideID)
+		}
+		if !reflect.DeepEqual(*tokens, tt.want) {
+			t.Errorf("%s: cost tokens = %q, want %q", tt.name, *tokens, tt.want)
+		}
+	}
+}
+
+func TestLineItemCategory(t *testing.T) {
+	tests := []struct {
+		desc, want string
+	}{
+		{"Tip", CategoryTip},
+		{"Gratuity", CategoryTip},
+		{"Multiple stops", CategoryOther},
+		{"Tolls", CategoryTolls},
+		{"Toll fee", CategoryTolls},
+		{"Service fee", CategoryFees},
+		{"Taxes", CategoryFees},
+		{"Taxi", CategoryOther},
+		{"Airport surcharge", CategoryFees},
+		{"Base fare", CategoryFare},
+		{"Primetime", CategoryFare},
+		{"Prime Time", CategoryFare},
+		{"Lifetime credit", CategoryFare},
+		{"Timeout", CategoryOther},
+		{"", CategoryOther},
+	}
+	for _, tt := range tests {
+		if got := (LineItem{Description: tt.desc}).Category(); got != tt.want {
+			t.Errorf("Category(%q) = %q, want %q", tt.desc, got, tt.want)
+		}
+	}
+}
+
+func TestReceiptCategories(t *testing.T) {
+	r := RideReceipt{LineItems: []LineItem{
+		{Money{800, "USD"}, "Base fare"},
+		{Money{250, "USD"}, "Time"},
+		{Money{300, "USD"}, "Tip"},
+		{Money{600, "USD"}, "Bridge toll"},
+		{Money{100, "USD"}, "Multiple stops"},
+	}}
+	for name, tt := range map[string]struct {
+		f    func() (Money, error)
+		want Money
+	}{
+		"Fare":  {r.Fare, Money{1050, "USD"}},
+		"Tip":   {r.Tip, Money{300, "USD"}},
+		"Tolls": {r.Tolls, Money{600, "USD"}},
+		"Fees":  {r.Fees, Money{}},
+		"Other": {r.Other, Money{100, "USD"}},
+	} {
+		got, err := tt.f()
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+		} else if got != tt.want {
+			t.Errorf("%s = %v, want %v", name, got, tt.want)
+		}
+	}
+}
+
+func TestRequestRideAndWaitDeadline(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			w.WriteHeader(201)
+			w.Write([]byte(`{"ride_id": "1", "status": "pending"}`))
+			return
+		}
+		w.Write([]byte(`{"ride_id": "1", "status": "pending"}`))
+	})
+	before := runtime.NumGoroutine()
+
+	ctx, cancel := context.WithTimeout(bg, 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	det, err := c.RequestRideAndWait(ctx, testRideRequest, StatusAccepted)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("returned after %v, want promptly after the deadline", elapsed)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want context.DeadlineExceeded", err)
+	}
+	if det.RideID != "1" || det.RideStatus != StatusPending {
+		t.Errorf("detail = %+v, want the latest pending detail", det)
+	}
+	checkGoroutines(t, c, before)
+}
+
+func TestReceiptChargeTotals(t *testing.T) {
+	tests := []struct {
+		name       string
+		charges    []Charge
+		total      Money
+		totalErr   error
+		byMethod   map[string]Money
+		byCurrency map[string]Money
+	}{
+		{
+			name: "single currency",
+			charges: []Charge{
+				{Money: Money{1000, "USD"}, PaymentMethod: "card"},
+				{Money: Money{360, "USD"}, PaymentMethod: "card"},
+				{Money: Money{200, "USD"}, PaymentMethod: "lyft_credit"},
+			},
+			total:      Money{1560, "USD"},
+			byMethod:   map[string]Money{"card": {1360, "USD"}, "lyft_credit": {200, "USD"}},
+			byCurrency: map[string]Money{"USD": {1560, "USD"}},
+		},
+		{
+			name: "mixed currencies",
+			charges: []Charge{
+				{Money: Money{1000, "USD"}, PaymentMethod: "card"},
+				{Money: Money{500, "CAD"}, PaymentMethod: "card"},
+			},
+			totalErr:   ErrMixedCurrencies,
+			byCurrency: map[string]Money{"USD": {1000, "USD"}, "CAD": {500, "CAD"}},
+		},
+		{
+			name: "refund",
+			charges: []Charge{
+				{Money: Money{1500, "USD"}, PaymentMethod: "card"},
+				{Money: Money{-500, "USD"}, PaymentMethod: "card"},
+			},
+			total:      Money{1000, "USD"},
+			byMethod:   map[string]Money{"card": {1000, "USD"}},
+			byCurrency: map[string]Money{"USD": {1000, "USD"}},
+		},
+	}
+	for _, tt := range tests {
+		r := RideReceipt{Charges: tt.charges}
+
+		total, err := r.Total()
+		if err != tt.totalErr || total != tt.total {
+			t.Errorf("%s: Total = %v, %v; want %v, %v", tt.name, total, err, tt.total, tt.totalErr)
+		}
+		byMethod, err := r.ChargesByPaymentMethod()
+		if err != tt.totalErr || !reflect.DeepEqual(byMethod, tt.byMethod) {
+			t.Errorf("%s: ChargesByPaymentMethod = %v, %v; want %v, %v", tt.name, byMethod, err, tt.byMethod, tt.totalErr)
+		}
+		if got := r.ChargeTotalsByCurrency(); !reflect.DeepEqual(got, tt.byCurrency) {
+			t.Errorf("%s: ChargeTotalsByCurrency = %v, want %v", tt.name, got, tt.byCurrency)
+		}
+	}
+}
+
+// cancelServer serves the ride history of the rides, keyed by ride ID with
+// their statuses, and records the cancel requests as "ID" or "ID:token".
+// Canceling ride "fee" requires the token "tok"; canceling ride "fail"
+// fails.
+func cancelServer(t *testing.T, rides map[string]string) (*Client, *[]string) {
+	var cancels []string
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" && r.URL.Path == "/v1/rides" {
+			var history []string
+			for id, status := range rides {
+				history = append(history, `{"ride_id": "`+id+`", "status": "`+status+`"}`)
+			}
+			w.Write([]byte(`{"ride_history": [` + strings.Join(history, ",") + `]}`))
+			return
+		}
+		id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/v1/rides/"), "/cancel")
+		var body struct {
+			Token string `json:"cancel_confirmation_token"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		if body.Token != "" {
+			cancels = append(cancels, id+":"+body.Token)
+		} else {
+			cancels = append(cancels, id)
+		}
+		switch {
+		case id == "fee" && body.Token != "tok":
+			w.WriteHeader(400)
+			w.Write([]byte(`{"error": "cancel_confirmation_required", "amount": 5, "currency": "USD", "token": "tok", "token_duration": 60}`))
+		case id == "fail":
+			w.WriteHeader(500)
+		default:
+			w.WriteHeader(204)
+		}
+	})
+	return c, &cancels
+}
+
+func TestCancelActiveRides(t *testing.T) {
+	tests := []struct {
+		name     string
+		rides    map[string]string
+		canceled []string
+		cancels  []string
+		failed   []string
+	}{
+		{"none active", map[string]string{"1": StatusDroppedOff, "2": StatusCanceled}, nil, nil, nil},
+		{"one", map[string]string{"1": StatusAccepted, "2": StatusDroppedOff}, []string{"1"}, []string{"1"}, nil},
+		{
+			"multiple with fee",
+			map[string]string{"1": StatusPending, "fee": StatusArrived, "3": StatusPickedUp},
+			[]string{"1", "3", "fee"},
+			[]string{"1", "3", "fee", "fee:tok"},
+			nil,
+		},
+		{
+			"failure",
+			map[string]string{"1": StatusPending, "fail": StatusAccepted},
+			[]string{"1"},
+			[]string{"1", "fail"},
+			[]string{"fail"},
+		},
+	}
+	for _, tt := range tests {
+		c, cancels := cancelServer(t, tt.rides)
+		canceled, err := c.CancelActiveRides(bg)
+		sort.Strings(canceled)
+		sort.Strings(*cancels)
+		if !reflect.DeepEqual(canceled, tt.canceled) {
+			t.Errorf("%s: canceled = %q, want %q", tt.name, canceled, tt.canceled)
+		}
+		if !reflect.DeepEqual(*cancels, tt.cancels) {
+			t.Errorf("%s: cancel requests = %q, want %q", tt.name, *cancels, tt.cancels)
+		}
+		if tt.failed == nil {
+			if err != nil {
+				t.Errorf("%s: %v", tt.name, err)
+			}
+			continue
+		}
+		cerr, ok := err.(*CancelActiveRidesError)
+		if !ok {
+			t.Errorf("%s: err = %v, want *CancelActiveRidesError", tt.name, err)
+			continue
+		}
+		var failed []string
+		for id, err := range cerr.Errors {
+			failed = append(failed, id)
+			if se, ok := err.(*StatusError); !ok || se.StatusCode != 500 {
+				t.Errorf("%s: error for %s = %v, want *StatusError with status code 500", tt.name, id, err)
+			}
+		}
+		if !reflect.DeepEqual(failed, tt.failed) {
+			t.Errorf("%s: failed = %q, want %q", tt.name, failed, tt.failed)
+		}
+	}
+}
+
+func TestCancelTokenCache(t *testing.T) {
+	var k cancelTokenCache
+	now := time.Now()
+	if _, ok := k.get("1", now); ok {
+		t.Error("empty cache has a token")
+	}
+
+	k.put("1", &CancelRideError{Token: "tok", TokenDuration: time.Minute}, now)
+	if tok, ok := k.get("1", now.Add(59*time.Second)); !ok || tok != "tok" {
+		t.Errorf("within TokenDuration: get = %q, %v; want tok", tok, ok)
+	}
+	if _, ok := k.get("2", now); ok {
+		t.Error("other ride has a token")
+	}
+	if _, ok := k.get("1", now.Add(time.Minute)); ok {
+		t.Error("token returned after TokenDuration")
+	}
+	if _, ok := k.get("1", now); ok {
+		t.Error("expired token not removed")
+	}
+
+	k.put("1", &CancelRideError{Token: "tok", TokenDuration: time.Minute}, now)
+	k.put("1", &CancelRideError{}, now)
+	if _, ok := k.get("1", now); ok {
+		t.Error("error without a token didn't replace the token")
+	}
+}
+
+func TestConfirmCancelRide(t *testing.T) {
+	c, cancels := cancelServer(t, nil)
+
+	// The first confirm has no token, and gets the fee and token.
+	if _, err := c.ConfirmCancelRide(bg, "fee"); err == nil {
+		t.Fatal("expected *CancelRideError")
+	}
+	// The token is reused within its duration.
+	if _, err := c.ConfirmCancelRide(bg, "fee"); err != nil {
+		t.Fatal(err)
+	}
+	// After the ride is canceled, the token is dropped.
+	if _, err := c.ConfirmCancelRide(bg, "fee"); err == nil {
+		t.Fatal("expected *CancelRideError")
+	}
+
+	// An expired token is not used; the ride's fee is fetched again.
+	if tok, ok := c.cancelTokens.get("fee", time.Now().Add(2*time.Minute)); ok {
+		t.Errorf("token %q not expired after its duration", tok)
+	}
+	if _, err := c.ConfirmCancelRide(bg, "fee"); err == nil {
+		t.Fatal("expected *CancelRideError after the token expired")
+	}
+	want := []string{"fee", "fee:tok", "fee", "fee"}
+	if !reflect.DeepEqual(*cancels, want) {
+		t.Errorf("cancel requests = %q, want %q", *cancels, want)
+	}
+}
+
+func TestPreflightRideTypes(t *testing.T) {
+	var posts int
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/v1/ridetypes":
+			w.Write([]byte(`{"ride_types": [
+				{"ride_type": "lyft", "can_request": false},
+				{"ride_type": "lyft_plus", "can_request": true},
+				{"ride_type": "lyft_line"}
+			]}`))
+		case r.Method == "POST" && r.URL.Path == "/v1/rides":
+			posts++
+			w.WriteHeader(201)
+			w.Write([]byte(`{"ride_id": "1", "status": "pending"}`))
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+	})
+	c.PreflightRideTypes = true
+
+	for _, rideType := range []string{RideTypeLyft, RideTypeLux} {
+		req := testRideRequest
+		req.RideType = rideType
+		_, _, err := c.RequestRide(req)
+		uerr, ok := err.(*RideTypeUnavailableError)
+		if !ok {
+			t.Errorf("%s: err = %v, want *RideTypeUnavailableError", rideType, err)
+			continue
+		}
+		if want := []string{RideTypePlus, RideTypeLine}; uerr.RideType != rideType || !reflect.DeepEqual(uerr.Available, want) {
+			t.Errorf("%s: err = %+v, want available %q", rideType, uerr, want)
+		}
+	}
+	if posts != 0 {
+		t.Errorf("rides requested for unavailable types: %d", posts)
+	}
+
+	req := testRideRequest
+	req.RideType = RideTypePlus
+	if _, _, err := c.RequestRide(req); err != nil {
+		t.Fatal(err)
+	}
+	if posts != 1 {
+		t.Errorf("rides requested = %d, want 1", posts)
+	}
+}
+
+// rideWaitServer responds to a ride request, then serves the ride detail
+// with the statuses in order; the last status is repeated. The sleeps
+// between polls are recorded instead of waited.
+func rideWaitServer(t *testing.T, statuses ...string) (*Client, *[]time.Duration) {
+	var polls int
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			w.WriteHeader(201)
+			w.Write([]byte(`{"ride_id": "1", "status": "pending"}`))
+			return
+		}
+		status := statuses[len(statuses)-1]
+		if polls < len(statuses) {
+			status = statuses[polls]
+		}
+		polls++
+		if status == "429" {
+			w.WriteHeader(429)
+			return
+		}
+		fmt.Fprintf(w, `{"ride_id": "1", "status": %q`, status)
+		if status != StatusPending && status != StatusCanceled {
+			w.Write([]byte(`, "driver": {"first_name": "Roger", "rating": "5"}, "vehicle": {"make": "Toyota", "model": "Prius", "license_plate": "7ABC123"}`))
+		}
+		w.Write([]byte(`}`))
+	})
+	var sleeps []time.Duration
+	c.sleep = func(ctx context.Context, d time.Duration) error {
+		sleeps = append(sleeps, d)
+		return nil
+	}
+	return c, &sleeps
+}
+
+func TestRequestRideAndWait(t *testing.T) {
+	c, sleeps := rideWaitServer(t, StatusPending, "429", StatusPending, StatusAccepted)
+	det, err := c.RequestRideAndWait(bg, testRideRequest, StatusAccepted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if det.RideStatus != StatusAccepted || det.Driver.FirstName != "Roger" || det.Vehicle.LicensePlate != "7ABC123" {
+		t.Errorf("detail = %+v, want accepted with driver and vehicle", det)
+	}
+	if want := []time.Duration{rideWaitInterval, rideWaitInterval, rideWaitInterval}; !reflect.DeepEqual(*sleeps, want) {
+		t.Errorf("sleeps = %v, want %v", *sleeps, want)
+	}
+
+	// A later status than the awaited one is returned too.
+	c, _ = rideWaitServer(t, StatusPickedUp)
+	if det, err := c.RequestRideAndWait(bg, testRideRequest, StatusAccepted); err != nil || det.RideStatus != StatusPickedUp {
+		t.Errorf("later status: detail, err = %+v, %v", det, err)
+	}
+}
+
+func TestRequestRideAndWaitCanceled(t *testing.T) {
+	c, _ := rideWaitServer(t, StatusPending, StatusCanceled)
+	det, err := c.RequestRideAndWait(bg, testRideRequest, StatusArrived)
+	if err != ErrRideCanceled {
+		t.Errorf("err = %v, want ErrRideCanceled", err)
+	}
+	if det.RideID != "1" || det.RideStatus != StatusCanceled {
+		t.Errorf("detail = %+v, want the canceled ride", det)
+	}
+}
+
+func TestCostTokenInfoToken(t *testing.T) {
+	tests := []struct {
+		body string
+		want string
+	}{
+		{`{"cost_token": "cost", "primetime_confirmation_token": "legacy", "token_duration": "300"}`, "cost"},
+		{`{"primetime_confirmation_token": "legacy", "token_duration": "300"}`, "legacy"},
+	}
+	for _, tt := range tests {
+		var c CostTokenInfo
+		if err := json.Unmarshal([]byte(tt.body), &c); err != nil {
+			t.Errorf("%s: %v", tt.body, err)
+			continue
+		}
+		if got := c.Token(); got != tt.want {
+			t.Errorf("%s: Token = %q, want %q", tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestSetDestinationLine(t *testing.T) {
+	rideTypes := map[string]string{"line": RideTypeLine, "classic": RideTypeLyft}
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(r.URL.Path, "/") // /v1/rides/{id}[/destination]
+		id := parts[3]
+		switch {
+		case r.Method == "PUT" && id == "ok":
+			w.Write([]byte(`{"lat": 37.79, "lng": -122.39, "address": "2 Market St"}`))
+		case r.Method == "PUT":
+			w.WriteHeader(400)
+			w.Write([]byte(`{"error": "bad_request"}`))
+		case r.Method == "GET":
+			fmt.Fprintf(w, `{"ride_id": %q, "ride_type": %q, "status": "accepted"}`, id, rideTypes[id])
+		}
+	})
+	dest := Location{Latitude: 37.79, Longitude: -122.39}
+
+	if _, _, err := c.SetDestination("line", dest); err != ErrLineUnsupported {
+		t.Errorf("Line ride: err = %v, want ErrLineUnsupported", err)
+	}
+	var serr *StatusError
+	if _, _, err := c.SetDestination("classic", dest); !errors.As(err, &serr) || serr.StatusCode != 400 {
+		t.Errorf("classic ride: err = %v, want *StatusError with status 400", err)
+	}
+	got, _, err := c.SetDestination("ok", dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Location{Latitude: 37.79, Longitude: -122.39, Address: "2 Market St"}); got != want {
+		t.Errorf("SetDestination = %+v, want %+v", got, want)
+	}
+}
+
+// rideDetailBody is a full ride detail response, for an accepted ride.
+const rideDetailBody = `{
+	"ride_id": "123456789",
+	"status": "accepted",
+	"ride_type": "lyft",
+	"origin": {"lat": 37.77, "lng": -122.41, "address": "1 Market St", "eta_seconds": 120},
+	"pickup": {"lat": 37.77, "lng": -122.41, "address": "1 Market St", "time": "2026-06-01T17:30:00Z"},
+	"destination": {"lat": 37.79, "lng": -122.39, "address": "Ferry Building", "eta_seconds": 900},
+	"location": {"lat": 37.7755, "lng": -122.4101, "bearing": 90},
+	"passenger": {"first_name": "Alice", "last_name": "A.", "image_url": "https://example.com/a.png", "rating": "5"},
+	"driver": {"first_name": "Bob", "phone_number": "+14155550100", "image_url": "https://example.com/b.png", "rating": "4.9"},
+	"vehicle": {"make": "Toyota", "model": "Prius", "year": 2020, "license_plate": "7ABC123", "license_plate_state": "CA", "color": "white"},
+	"primetime_percentage": "25%",
+	"price": {"amount": 1500, "currency": "USD", "description": "Estimated"},
+	"line_items": [{"amount": 1200, "currency": "USD", "type": "Ride"}, {"amount": 300, "currency": "USD", "type": "Primetime"}],
+	"requested_at": "2026-06-01T17:25:00Z",
+	"beacon_string": "#FF00BF",
+	"route_url": "https://www.lyft.com/routes/123456789",
+	"can_cancel": ["driver", "passenger", "dispatcher"]
+}`
+
+func TestRideProgress(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/rides/123456789" {
+			t.Errorf("path = %s", r.URL.Path)
+		}
+		w.Write([]byte(rideDetailBody))
+	})
+	p, _, err := c.RideProgress("123456789")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := RideProgress{
+		RideID:     "123456789",
+		RideStatus: StatusAccepted,
+		Location:   VehicleLocation{Latitude: 37.7755, Longitude: -122.4101, Bearing: 90},
+	}
+	if p != want {
+		t.Errorf("RideProgress = %+v, want %+v", p, want)
+	}
+
+	// The progress agrees with the full detail.
+	var d RideDetail
+	if err := json.Unmarshal([]byte(rideDetailBody), &d); err != nil {
+		t.Fatal(err)
+	}
+	if d.RideID != p.RideID || d.RideStatus != p.RideStatus || d.Location != p.Location {
+		t.Errorf("RideDetail = %+v, want ride ID, status, and location of %+v", d, p)
+	}
+}
+
+func BenchmarkRideProgressDecode(b *testing.B) {
+	benchmarkDecode(b, func() interface{} { return new(RideProgress) })
+}
+
+func BenchmarkRideDetailDecode(b *testing.B) {
+	benchmarkDecode(b, func() interface{} { return new(RideDetail) })
+}
+
+func benchmarkDecode(b *testing.B, newValue func() interface{}) {
+	p := []byte(rideDetailBody)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(p)))
+	for i := 0; i < b.N; i++ {
+		if err := JSONUnmarshal(p, newValue()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestRealizedRates(t *testing.T) {
+	rec := RideReceipt{RideID: "1", Price: Price{Money: Money{2000, "USD"}, Valid: true}}
+	ride := RideDetail{RideID: "1", Distance: 3, Duration: 16 * time.Minute}
+	perMile, perMinute, err := RealizedRates(&rec, &ride)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perMile != (Money{667, "USD"}) || perMinute != (Money{125, "USD"}) {
+		t.Errorf("RealizedRates = %v, %v, want 6.67 USD, 1.25 USD", perMile, perMinute)
+	}
+
+	tests := []struct {
+		name string
+		rec  RideReceipt
+		ride RideDetail
+	}{
+		{"other ride", rec, RideDetail{RideID: "2", Distance: 3, Duration: 16 * time.Minute}},
+		{"no price", RideReceipt{RideID: "1"}, ride},
+		{"no distance", rec, RideDetail{RideID: "1", Duration: 16 * time.Minute}},
+		{"no duration", rec, RideDetail{RideID: "1", Distance: 3}},
+	}
+	for _, tt := range tests {
+		if _, _, err := RealizedRates(&tt.rec, &tt.ride); err == nil {
+			t.Errorf("%s: expected error", tt.name)
+		}
+	}
+}
+
+func TestRequestRidePaymentRequired(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(402)
+		w.Write([]byte(`{"error": "invalid_payment", "error_description": "The payment method was declined",
+			"error_uri": "https://www.lyft.com/payment"}`))
+	})
+	_, _, err := c.RequestRide(testRideRequest)
+	if !IsPaymentRequired(err) {
+		t.Fatalf("IsPaymentRequired(%v) = false, want true", err)
+	}
+	serr := err.(*StatusError)
+	if serr.Reason != "invalid_payment" || serr.URI != "https://www.lyft.com/payment" {
+		t.Errorf("Reason = %q, URI = %q", serr.Reason, serr.URI)
+	}
+
+	for _, err := range []error{&StatusError{StatusCode: 400}, errors.New("402"), nil} {
+		if IsPaymentRequired(err) {
+			t.Errorf("IsPaymentRequired(%v) = true, want false", err)
+		}
+	}
+}
+
+func TestChargeValid(t *testing.T) {
+	tests := []struct {
+		body string
+		want Charge
+	}{
+		{`{"amount": 0, "currency": "USD", "payment_method": "credits"}`, Charge{Money{0, "USD"}, "credits", true}},
+		{`{"amount": 1250, "currency": "USD", "payment_method": "card"}`, Charge{Money{1250, "USD"}, "card", true}},
+		{`{"currency": "USD", "payment_method": "card"}`, Charge{Money{0, "USD"}, "card", false}},
+	}
+	for _, tt := range tests {
+		var c Charge
+		if err := json.Unmarshal([]byte(tt.body), &c); err != nil {
+			t.Errorf("%s: %v", tt.body, err)
+			continue
+		}
+		if c != tt.want {
+			t.Errorf("%s: Charge = %+v, want %+v", tt.body, c, tt.want)
+		}
+	}
+}
+
+func TestReconcileReceipts(t *testing.T) {
+	usd := func(amount int) Price { return Price{Money: Money{amount, "USD"}, Valid: true} }
+	charge := func(amount int, currency, method string) Charge {
+		return Charge{Money{amount, currency}, method, true}
+	}
+	receipts := []RideReceipt{
+		{RideID: "match", Price: usd(1500), Charges: []Charge{charge(1000, "USD", "card"), charge(500, "USD", "credits")}},
+		{RideID: "refund", Price: usd(800), Charges: []Charge{charge(1000, "USD", "card"), charge(-200, "USD", "card")}},
+		{RideID: "short", Price: usd(2000), Charges: []Charge{charge(1800, "USD", "card")}},
+		{RideID: "mixed", Price: usd(900), Charges: []Charge{charge(900, "CAD", "card")}},
+		{RideID: "no price", Charges: []Charge{charge(300, "USD", "card")}},
+	}
+	report := ReconcileReceipts(receipts)
+
+	wantTotals := []ChargeTotal{
+		{"card", Money{900, "CAD"}, 1},
+		{"card", Money{3900, "USD"}, 5},
+		{"credits", Money{500, "USD"}, 1},
+	}
+	if !reflect.DeepEqual(report.Totals, wantTotals) {
+		t.Errorf("Totals = %v, want %v", report.Totals, wantTotals)
+	}
+
+	wantMismatches := []ReceiptMismatch{
+		{RideID: "short", Price: usd(2000), Charged: Money{1800, "USD"}},
+		{RideID: "mixed", Price: usd(900), Charged: Money{0, "USD"}, Err: ErrMixedCurrencies},
+	}
+	if !reflect.DeepEqual(report.Mismatches, wantMismatches) {
+		t.Errorf("Mismatches = %+v, want %+v", report.Mismatches, wantMismatches)
+	}
+}
+
+func TestRequestRideAccepted(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		want   CreatedRide
+		ok     bool
+	}{
+		{"201", 201, `{"ride_id": "1", "status": "pending", "ride_type": "lyft"}`, CreatedRide{RideID: "1", RideStatus: StatusPending, RideType: RideTypeLyft}, true},
+		{"202", 202, `{"ride_id": "1", "status": "pending", "ride_type": "lyft"}`, CreatedRide{RideID: "1", RideStatus: StatusPending, RideType: RideTypeLyft}, true},
+		{"202 empty body", 202, ``, CreatedRide{}, true},
+		{"202 blank body", 202, "\n", CreatedRide{}, true},
+		{"201 invalid body", 201, `{"ride_id": `, CreatedRide{}, false},
+	}
+	for _, tt := range tests {
+		c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tt.status)
+			w.Write([]byte(tt.body))
+		})
+		cr, _, err := c.RequestRide(testRideRequest)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: err = %v, want ok %v", tt.name, err, tt.ok)
+			continue
+		}
+		if cr != tt.want {
+			t.Errorf("%s: CreatedRide = %+v, want %+v", tt.name, cr, tt.want)
+		}
+	}
+}
+
+func TestReceiptTotalsByCurrency(t *testing.T) {
+	const body = `{"ride_id": "1", "price": {"amount": 2450, "currency": "USD"}, "line_items": [
+		{"amount": 2000, "currency": "USD", "type": "Ride"},
+		{"amount": 450, "currency": "USD", "type": "Tip"},
+		{"amount": 300, "currency": "CAD", "type": "Bridge toll"},
+		{"amount": 150, "currency": "CAD", "type": "Airport fee"}
+	]}`
+	var rec RideReceipt
+	if err := json.Unmarshal([]byte(body), &rec); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]Money{"USD": {2450, "USD"}, "CAD": {450, "CAD"}}
+	if got := rec.TotalsByCurrency(); !reflect.DeepEqual(got, want) {
+		t.Errorf("TotalsByCurrency = %v, want %v", got, want)
+	}
+	if got := (&RideReceipt{}).TotalsByCurrency(); len(got) != 0 {
+		t.Errorf("no line items: TotalsByCurrency = %v, want empty", got)
+	}
+}
+
+func TestShareLink(t *testing.T) {
+	rides := map[string]string{
+		"active":   `{"ride_id": "active", "status": "pickedUp", "route_url": "https://www.lyft.com/routes/active"}`,
+		"done":     `{"ride_id": "done", "status": "droppedOff", "route_url": "https://www.lyft.com/routes/done"}`,
+		"canceled": `{"ride_id": "canceled", "status": "canceled", "route_url": "https://www.lyft.com/routes/canceled"}`,
+		"nourl":    `{"ride_id": "nourl", "status": "accepted"}`,
+	}
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(rides[strings.TrimPrefix(r.URL.Path, "/v1/rides/")]))
+	})
+
+	link, err := c.ShareLink(bg, "active")
+	if err != nil || link != "https://www.lyft.com/routes/active" {
+		t.Errorf("active ride: ShareLink = %q, %v", link, err)
+	}
+	for _, id := range []string{"done", "canceled", "nourl"} {
+		if link, err := c.ShareLink(bg, id); err != ErrNotShareable || link != "" {
+			t.Errorf("%s: ShareLink = %q, %v, want ErrNotShareable", id, link, err)
+		}
+	}
+}
+
+func TestRequestRideDefaultRideType(t *testing.T) {
+	tests := []struct {
+		defaultType, rideType, want string
+	}{
+		{RideTypePlus, "", RideTypePlus},
+		{RideTypePlus, RideTypeLyft, RideTypeLyft},
+		{"", RideTypeLyft, RideTypeLyft},
+		{"", "", ""},
+	}
+	for _, tt := range tests {
+		var sent RideRequest
+		c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			if err := json.NewDecoder(r.Body).Decode(&sent); err != nil {
+				t.Error(err)
+			}
+			w.WriteHeader(201)
+			w.Write([]byte(`{"ride_id": "1", "status": "pending"}`))
+		})
+		c.DefaultRideType = tt.defaultType
+		req := testRideRequest
+		req.RideType = tt.rideType
+		if _, _, err := c.RequestRide(req); err != nil {
+			t.Fatal(err)
+		}
+		if sent.RideType != tt.want {
+			t.Errorf("DefaultRideType %q, RideType %q: sent ride_type %q, want %q", tt.defaultType, tt.rideType, sent.RideType, tt.want)
+		}
+	}
+}
+
+func TestRateRide(t *testing.T) {
+	var bodies []map[string]interface{}
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PUT" || r.URL.Path != "/v1/rides/1/rating" {
+			t.Errorf("request = %s %s", r.Method, r.URL.Path)
+		}
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+		bodies = append(bodies, body)
+		w.WriteHeader(204)
+	})
+
+	for _, rating := range []int{0, 6, -1} {
+		if _, err := c.RateRide("1", rating, "great"); err == nil {
+			t.Errorf("rating %d: expected error", rating)
+		}
+	}
+	if len(bodies) != 0 {
+		t.Fatalf("invalid ratings: %d requests made, want none", len(bodies))
+	}
+
+	if _, err := c.RateRide("1", 5, "Great driver"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.RateRide("1", 1, ""); err != nil {
+		t.Fatal(err)
+	}
+	want := []map[string]interface{}{
+		{"rating": 5.0, "feedback": "Great driver"},
+		{"rating": 1.0},
+	}
+	if !reflect.DeepEqual(bodies, want) {
+		t.Errorf("bodies = %v, want %v", bodies, want)
+	}
+}
@@ -0,0 +1,131 @@
+package lyft
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// AdvanceSandboxRide advances the sandbox ride to the status to. Lyft's sandbox
+// does not allow skipping statuses, so each intermediate status is set in order.
+// The returned header is the header from the last request made.
+//
+// to must be one of StatusAccepted, StatusArrived, StatusPickedUp,
+// StatusDroppedOff, or StatusCanceled, and must come after the ride's current
+// status. A ride that isn't dropped off or canceled can be canceled directly.
+func (c *Client) AdvanceSandboxRide(ctx context.Context, rideID, to string) (http.Header, error) {
+	target := lifecycleIndex(to)
+	if target <= 0 && to != StatusCanceled {
+		return nil, fmt.Errorf("invalid sandbox ride status %q", to)
+	}
+
+	det, h, err := c.rideDetail(ctx, rideID)
+	if err != nil {
+		return h, contextError(ctx, err)
+	}
+	cur := lifecycleIndex(det.RideStatus)
+	if cur == -1 || cur == len(rideLifecycle)-1 {
+		return h, fmt.Errorf("cannot advance ride with status %q", det.RideStatus)
+	}
+
+	if to == StatusCanceled {
+		h, err := c.setSandboxRideStatus(ctx, rideID, to)
+		return h, contextError(ctx, err)
+	}
+	if target <= cur {
+		return h, fmt.Errorf("cannot advance ride from status %q to %q", det.RideStatus, to)
+	}
+	for _, s := range rideLifecycle[cur+1 : target+1] {
+		h, err = c.setSandboxRideStatus(ctx, rideID, s)
+		if err != nil {
+			return h, contextError(ctx, err)
+		}
+	}
+	return h, nil
+}
+
+// SetSandboxRideStatus sets the status of a ride created in Lyft's sandbox.
+// The status must be one of StatusAccepted, StatusArrived, StatusPickedUp,
+// StatusDroppedOff, or StatusCanceled. Lyft's sandbox requires the statuses
+// to be set in order; see AdvanceSandboxRide.
+func (c *Client) SetSandboxRideStatus(rideID, status string) (http.Header, error) {
+	return c.SetSandboxRideStatusContext(context.Background(), rideID, status)
+}
+
+// SetSandboxRideStatusContext is like SetSandboxRideStatus, but the request is made with the
+// supplied context. If the context is done, the error wraps the context's
+// error.
+func (c *Client) SetSandboxRideStatusContext(ctx context.Context, rideID, status string) (http.Header, error) {
+	h, err := c.setSandboxRideStatus(ctx, rideID, status)
+	return h, contextError(ctx, err)
+}
+
+func (c *Client) setSandboxRideStatus(ctx context.Context, rideID, status string) (http.Header, error) {
+	if lifecycleIndex(status) <= 0 && status != StatusCanceled {
+		return nil, fmt.Errorf("invalid sandbox ride status %q", status)
+	}
+	return c.send(ctx, request{
+		method: "PUT",
+		path:   fmt.Sprintf("/v1/sandbox/rides/%s", rideID),
+		body: struct {
+			Status string `json:"status"`
+		}{status},
+		success: []int{200, 204},
+	}, nil)
+}
+
+// SetSandboxRideType sets whether drivers are available for the ride type
+// at the location in Lyft's sandbox. Setting available to false is useful
+// for testing the handling of locations where no drivers are available.
+func (c *Client) SetSandboxRideType(lat, lng float64, rideType string, available bool) (http.Header, error) {
+	return c.SetSandboxRideTypeContext(context.Background(), lat, lng, rideType, available)
+}
+
+// SetSandboxRideTypeContext is like SetSandboxRideType, but the request is made with the
+// supplied context. If the context is done, the error wraps the context's
+// error.
+func (c *Client) SetSandboxRideTypeContext(ctx context.Context, lat, lng float64, rideType string, available bool) (http.Header, error) {
+	if rideType == "" {
+		return nil, errors.New("empty sandbox ride type")
+	}
+	h, err := c.send(ctx, request{
+		method: "PUT",
+		path:   "/v1/sandbox/ridetypes/" + url.PathEscape(rideType),
+		body: struct {
+			Latitude           float64 `json:"lat"`
+			Longitude          float64 `json:"lng"`
+			DriverAvailability bool    `json:"driver_availability"`
+		}{lat, lng, available},
+		success: []int{200, 204},
+	}, nil)
+	return h, contextError(ctx, err)
+}
+
+// SetSandboxPrimetime sets the primetime percentage, such as "25%", at the
+// location in Lyft's sandbox. Setting a primetime percentage is useful for
+// testing the handling of RideRequestError and CostTokenInfo.
+func (c *Client) SetSandboxPrimetime(lat, lng float64, percentage string) (http.Header, error) {
+	return c.SetSandboxPrimetimeContext(context.Background(), lat, lng, percentage)
+}
+
+// SetSandboxPrimetimeContext is like SetSandboxPrimetime, but the request is made with the
+// supplied context. If the context is done, the error wraps the context's
+// error.
+func (c *Client) SetSandboxPrimetimeContext(ctx context.Context, lat, lng float64, percentage string) (http.Header, error) {
+	if _, err := parsePrimetimePercentage(percentage); err != nil {
+		return nil, err
+	}
+	h, err := c.send(ctx, request{
+		method: "PUT",
+		path:   "/v1/sandbox/primetime",
+		body: struct {
+			Latitude            float64 `json:"lat"`
+			Longitude           float64 `json:"lng"`
+			PrimetimePercentage string  `json:"primetime_percentage"`
+		}{lat, lng, percentage},
+		success: []int{200, 204},
+	}, nil)
+	return h, contextError(ctx, err)
+}
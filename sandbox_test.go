@@ -0,0 +1,126 @@
+package lyft
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// sandboxServer serves a ride with the status, and records the statuses set
+// by the PUT requests. The ride's status follows the PUT requests.
+func sandboxServer(t *testing.T, status string) (*Client, *[]string, *int) {
+	var puts []string
+	var gets int
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/v1/rides/1":
+			gets++
+			json.NewEncoder(w).Encode(map[string]string{"ride_id": "1", "status": status})
+		case r.Method == "PUT" && r.URL.Path == "/v1/sandbox/rides/1":
+			var body struct {
+				Status string `json:"status"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				t.Error(err)
+			}
+			puts = append(puts, body.Status)
+			status = body.Status
+			w.WriteHeader(204)
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+	})
+	return c, &puts, &gets
+}
+
+func TestAdvanceSandboxRide(t *testing.T) {
+	tests := []struct {
+		name     string
+		from, to string
+		want     []string // statuses set
+		err      bool
+	}{
+		{"full lifecycle", StatusPending, StatusDroppedOff, []string{StatusAccepted, StatusArrived, StatusPickedUp, StatusDroppedOff}, false},
+		{"one step", StatusAccepted, StatusArrived, []string{StatusArrived}, false},
+		{"cancel", StatusArrived, StatusCanceled, []string{StatusCanceled}, false},
+		{"same status", StatusArrived, StatusArrived, nil, true},
+		{"backwards", StatusPickedUp, StatusAccepted, nil, true},
+		{"already canceled", StatusCanceled, StatusDroppedOff, nil, true},
+		{"cancel canceled", StatusCanceled, StatusCanceled, nil, true},
+		{"cancel dropped off", StatusDroppedOff, StatusCanceled, nil, true},
+	}
+	for _, tt := range tests {
+		c, puts, _ := sandboxServer(t, tt.from)
+		_, err := c.AdvanceSandboxRide(bg, "1", tt.to)
+		if (err != nil) != tt.err {
+			t.Errorf("%s: err = %v, want error %v", tt.name, err, tt.err)
+		}
+		if !reflect.DeepEqual(*puts, tt.want) {
+			t.Errorf("%s: statuses set = %q, want %q", tt.name, *puts, tt.want)
+		}
+	}
+}
+
+func TestAdvanceSandboxRideInvalidTarget(t *testing.T) {
+	for _, to := range []string{StatusPending, "bogus", ""} {
+		c, puts, gets := sandboxServer(t, StatusPending)
+		if _, err := c.AdvanceSandboxRide(bg, "1", to); err == nil {
+			t.Errorf("%q: expected error", to)
+		}
+		if *gets != 0 || len(*puts) != 0 {
+			t.Errorf("%q: requests were made", to)
+		}
+	}
+}
+
+func TestClientSandbox(t *testing.T) {
+	for _, sandbox := range []bool{true, false} {
+		c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case r.Method == "POST" && r.URL.Path == "/v1/rides":
+				w.WriteHeader(201)
+				w.Write([]byte(`{"ride_id": "1", "status": "pending"}`))
+			case r.URL.Path == "/v1/rides":
+				w.Write([]byte(`{"ride_history": [{"ride_id": "1", "status": "droppedOff"}, {"ride_id": "2", "status": "canceled"}]}`))
+			case r.URL.Path == "/v1/rides/1":
+				w.Write([]byte(`{"ride_id": "1", "status": "accepted"}`))
+			case r.URL.Path == "/v1/rides/1/receipt":
+				w.Write([]byte(`{"ride_id": "1", "price": {"amount": 1000, "currency": "USD"}}`))
+			}
+		})
+		c.Sandbox = sandbox
+
+		cr, _, err := c.RequestRide(testRideRequest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cr.Sandbox != sandbox {
+			t.Errorf("Sandbox %v: CreatedRide.Sandbox = %v", sandbox, cr.Sandbox)
+		}
+		det, _, err := c.RideDetail("1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if det.Sandbox != sandbox {
+			t.Errorf("Sandbox %v: RideDetail.Sandbox = %v", sandbox, det.Sandbox)
+		}
+		history, _, err := c.RideHistory(historyBase, time.Time{}, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, r := range history {
+			if r.Sandbox != sandbox {
+				t.Errorf("Sandbox %v: ride %s in RideHistory: Sandbox = %v", sandbox, r.RideID, r.Sandbox)
+			}
+		}
+		rec, _, err := c.RideReceipt("1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rec.Sandbox != sandbox {
+			t.Errorf("Sandbox %v: RideReceipt.Sandbox = %v", sandbox, rec.Sandbox)
+		}
+	}
+}
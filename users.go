@@ -1,10 +1,15 @@
 package lyft
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -39,6 +44,32 @@ func RideStatusDisplay(s string) string {
 	return s
 }
 
+// rideLifecycle is the order of the statuses of a ride that isn't canceled.
+var rideLifecycle = []string{StatusPending, StatusAccepted, StatusArrived, StatusPickedUp, StatusDroppedOff}
+
+// lifecycleIndex returns the index of the status in rideLifecycle,
+// or -1 if it isn't present.
+func lifecycleIndex(s string) int {
+	for i, l := range rideLifecycle {
+		if l == s {
+			return i
+		}
+	}
+	return -1
+}
+
+// CancelRole is a role that can cancel a ride. It is used in the
+// RideDetail's CanCancel and CanceledBy fields.
+type CancelRole string
+
+// Cancel roles. May not be an exhaustive list; unknown values are
+// preserved as-is.
+const (
+	RoleDriver    CancelRole = "driver"
+	RolePassenger CancelRole = "passenger"
+	RoleSystem    CancelRole = "system"
+)
+
 // Ride profiles.
 const (
 	ProfileBusiness = "business"
@@ -66,13 +97,16 @@ type rideDetail struct {
 	Duration            float64           `json:"duration_seconds"` // Documented as float64
 	Price               Price             `json:"price"`
 	LineItems           []LineItem        `json:"line_items"`
-	Requested           string            `json:"requested_at"`
+	Requested           flexTime          `json:"requested_at"`
 	RideProfile         string            `json:"ride_profile"`
-	BeaconColor         string            `json:"beacon_string"`
+	ExpenseCode         string            `json:"expense_code"`
+	ExpenseNote         string            `json:"expense_note"`
+	BeaconColor         *string           `json:"beacon_string"` // nil if absent
 	PricingDetailsURL   string            `json:"pricing_details_url"`
 	RouteURL            string            `json:"route_url"`
-	CanCancel           []string          `json:"can_cancel"`
-	CanceledBy          string            `json:"canceled_by"`
+	Route               []Location        `json:"route"`
+	CanCancel           []CancelRole      `json:"can_cancel"`
+	CanceledBy          CancelRole        `json:"canceled_by"`
 	CancellationPrice   cancellationPrice `json:"cancellation_price"`
 	Rating              int               `json:"rating"`
 	Feedback            string            `json:"feedback"`
@@ -108,17 +142,17 @@ func (r rideDetail) convert(res *RideDetail) error {
 	res.Duration = time.Second * time.Duration(r.Duration)
 	res.Price = r.Price
 	res.LineItems = r.LineItems
-	if r.Requested != "" {
-		requested, err := time.Parse(TimeLayout, r.Requested)
-		if err != nil {
-			return err
-		}
-		res.Requested = requested
-	}
+	res.Requested = r.Requested.Time
 	res.RideProfile = r.RideProfile
-	res.BeaconColor = r.BeaconColor
+	res.ExpenseCode = r.ExpenseCode
+	res.ExpenseNote = r.ExpenseNote
+	if r.BeaconColor != nil {
+		res.BeaconColor = *r.BeaconColor
+		res.beacon = true
+	}
 	res.PricingDetailsURL = r.PricingDetailsURL
 	res.RouteURL = r.RouteURL
+	res.Route = r.Route
 	res.CanCancel = r.CanCancel
 	res.CanceledBy = r.CanceledBy
 	err = r.CancellationPrice.convert(&res.CancellationPrice)
@@ -154,15 +188,13 @@ func (l rideLocation) convert(res *RideLocation) error {
 }
 
 type cancellationPrice struct {
-	Amount        int    `json:"amount"`
-	Currency      string `json:"currency"`
+	Money
 	Token         string `json:"token"`
 	TokenDuration int64  `json:"token_duration"` // seconds; documented as int
 }
 
 func (c cancellationPrice) convert(res *CancellationPrice) error {
-	res.Amount = c.Amount
-	res.Currency = c.Currency
+	res.Money = c.Money
 	res.Token = c.Token
 	res.TokenDuration = time.Second * time.Duration(c.TokenDuration) // TODO: consider not truncating
 	return nil
@@ -191,14 +223,65 @@ type RideDetail struct {
 	LineItems           []LineItem
 	Requested           time.Time
 	RideProfile         string
+	ExpenseCode         string // Business expense code; typically set only for ProfileBusiness rides.
+	ExpenseNote         string // Business expense memo; typically set only for ProfileBusiness rides.
 	BeaconColor         string
 	PricingDetailsURL   string
 	RouteURL            string
-	CanCancel           []string
-	CanceledBy          string
+	Route               []Location // Waypoints of the route, if embedded in the response; otherwise nil. See also RouteURL.
+	CanCancel           []CancelRole
+	CanceledBy          CancelRole
 	CancellationPrice   CancellationPrice
 	Rating              int
 	Feedback            string
+	// Whether the ride was returned by a sandbox client, or is from a
+	// sandbox webhook event.
+	Sandbox bool
+
+	beacon bool // whether the response included beacon information
+}
+
+// PrimetimeFraction returns PrimetimePercentage as a fraction; for example,
+// "25%" is 0.25. It returns 0 if PrimetimePercentage is empty.
+func (r *RideDetail) PrimetimeFraction() (float64, error) {
+	return parsePrimetimePercentage(r.PrimetimePercentage)
+}
+
+// HasBeacon returns whether the ride's driver has an Amp beacon assigned.
+// If HasBeacon is true but BeaconColor is empty, the driver has a beacon
+// but its color is unknown. If HasBeacon is false, BeaconColor is empty.
+func (r *RideDetail) HasBeacon() bool {
+	return r.beacon || r.BeaconColor != ""
+}
+
+// PickupCountdown returns the remaining time until the driver picks up the
+// passenger. If the ride has a pickup time, the duration is measured from
+// now to the pickup time, and is zero if the pickup time has passed.
+// Otherwise the origin's ETA is returned as is; since the ETA is relative to
+// when the ride detail was retrieved, refresh the ride detail to update a
+// countdown. The ok result is false if neither is available.
+func (r *RideDetail) PickupCountdown(now time.Time) (d time.Duration, ok bool) {
+	if !r.Pickup.Time.IsZero() {
+		d = r.Pickup.Time.Sub(now)
+		if d < 0 {
+			d = 0
+		}
+		return d, true
+	}
+	if r.Origin.ETA > 0 {
+		return r.Origin.ETA, true
+	}
+	return 0, false
+}
+
+// WaitTime returns how long the passenger waited between requesting the
+// ride and being picked up. The ok result is false if either time is
+// missing, such as for rides that haven't been picked up.
+func (r *RideDetail) WaitTime() (d time.Duration, ok bool) {
+	if r.Requested.IsZero() || r.Pickup.Time.IsZero() {
+		return 0, false
+	}
+	return r.Pickup.Time.Sub(r.Requested), true
 }
 
 type RideLocation struct {
@@ -222,6 +305,106 @@ type Person struct {
 	ImageURL  string `json:"image_url"`
 	Rating    string `json:"rating"`
 	Phone     string `json:"phone_number"`
+	// Rating as a number, such as 4.9. Zero if the rating is absent or
+	// isn't a number.
+	RatingValue float64 `json:"-"`
+	// Number of rides, if included in the response; typically set only
+	// for drivers.
+	RidesCount int `json:"rides_count"`
+}
+
+// UnmarshalJSON decodes the person. The rating may be either a string
+// or a number.
+func (p *Person) UnmarshalJSON(b []byte) error {
+	type person Person // Prevents recursion.
+	var aux struct {
+		person
+		Rating json.RawMessage `json:"rating"`
+	}
+	if err := JSONUnmarshal(b, &aux); err != nil {
+		return err
+	}
+	*p = Person(aux.person)
+	if len(aux.Rating) == 0 || isJSONNull(aux.Rating) {
+		return nil
+	}
+	if aux.Rating[0] == '"' {
+		if err := JSONUnmarshal(aux.Rating, &p.Rating); err != nil {
+			return err
+		}
+		p.RatingValue, _ = strconv.ParseFloat(strings.TrimSpace(p.Rating), 64)
+		return nil
+	}
+	if err := JSONUnmarshal(aux.Rating, &p.RatingValue); err != nil {
+		return err
+	}
+	p.Rating = string(aux.Rating)
+	return nil
+}
+
+// NormalizedPhone returns the person's phone number in E.164 format, such as
+// "+14155550100", and the extension, if any, in digits. Numbers without a
+// country code are assumed to be North American numbers. If the phone
+// number can't be normalized, number is the unmodified Phone field and ext
+// is empty.
+//
+// The number and extension can be used in a tel URI as
+// "tel:" + number + ";ext=" + ext.
+func (p Person) NormalizedPhone() (number, ext string) {
+	main, ext := splitPhoneExtension(strings.TrimSpace(p.Phone))
+
+	var digits strings.Builder
+	for i, r := range main {
+		switch {
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case r == '+' && i == 0:
+		case strings.ContainsRune(" -.()", r):
+		default:
+			return p.Phone, ""
+		}
+	}
+
+	d := digits.String()
+	switch {
+	case strings.HasPrefix(main, "+") && len(d) >= 8 && len(d) <= 15:
+		return "+" + d, ext
+	case len(d) == 10:
+		return "+1" + d, ext
+	case len(d) == 11 && d[0] == '1':
+		return "+" + d, ext
+	}
+	return p.Phone, ""
+}
+
+// splitPhoneExtension splits the phone number into the number and the
+// digits of the extension. An extension marker counts only if it is
+// followed by the extension's digits, optionally separated by spaces or
+// commas (which dialers treat as pauses); otherwise s is returned
+// unsplit.
+func splitPhoneExtension(s string) (string, string) {
+	lower := strings.ToLower(s)
+	for _, sep := range []string{";ext=", "ext.", "ext", "x", ",", "#"} {
+		i := strings.Index(lower, sep)
+		if i <= 0 {
+			continue
+		}
+		var ext strings.Builder
+		for _, r := range s[i+len(sep):] {
+			switch {
+			case r >= '0' && r <= '9':
+				ext.WriteRune(r)
+			case r == ' ' || r == ',':
+			default:
+				return s, ""
+			}
+		}
+		if ext.Len() == 0 {
+			return s, ""
+		}
+		return s[:i], ext.String()
+	}
+	return s, ""
 }
 
 type Vehicle struct {
@@ -235,74 +418,216 @@ type Vehicle struct {
 }
 
 type Price struct {
-	Amount      int    `json:"amount"`
-	Currency    string `json:"currency"`
+	Money
 	Description string `json:"description"`
+	// Valid is true if the response included the amount. It distinguishes
+	// a zero amount from a missing price.
+	Valid bool `json:"-"`
+	// Itemized breakdown of the price, if the response included one;
+	// otherwise nil. See also the ride's LineItems.
+	Breakdown []LineItem `json:"-"`
+}
+
+// String returns the amount and the description, such as
+// "12.34 USD (Lyft fare)". A missing amount (see Valid) is noted.
+func (p Price) String() string {
+	s := p.Money.String()
+	if p.Description != "" {
+		s += " (" + p.Description + ")"
+	}
+	if !p.Valid {
+		s += " [amount missing]"
+	}
+	return s
+}
+
+// UnmarshalJSON decodes either the simple price object documented in the Lyft
+// API reference, or a detailed object whose total is in a "total" object and
+// whose items are in a "breakdown" array. The flat fields are set in both cases.
+func (p *Price) UnmarshalJSON(b []byte) error {
+	type flatPrice struct {
+		Amount      *int   `json:"amount"`
+		Currency    string `json:"currency"`
+		Description string `json:"description"`
+	}
+	var aux struct {
+		flatPrice
+		Total     *flatPrice `json:"total"`
+		Breakdown []LineItem `json:"breakdown"`
+	}
+	if err := JSONUnmarshal(b, &aux); err != nil {
+		return err
+	}
+	flat := aux.flatPrice
+	if flat.Amount == nil && aux.Total != nil {
+		flat = *aux.Total
+	}
+	*p = Price{
+		Money:       Money{Currency: flat.Currency},
+		Description: flat.Description,
+		Breakdown:   aux.Breakdown,
+	}
+	if flat.Amount != nil {
+		p.Amount = *flat.Amount
+		p.Valid = true
+	}
+	return nil
 }
 
 type LineItem struct {
-	Amount      int    `json:"amount"`
-	Currency    string `json:"currency"`
+	Money
 	Description string `json:"type"`
 }
 
+// String returns the description and the amount, such as "Tip: 2.00 USD".
+func (li LineItem) String() string {
+	if li.Description == "" {
+		return li.Money.String()
+	}
+	return li.Description + ": " + li.Money.String()
+}
+
 type CancellationPrice struct {
-	Amount        int
-	Currency      string
+	Money
 	Token         string
 	TokenDuration time.Duration
 }
 
+// String returns the amount and, if set, the token and its duration, such
+// as "5.00 USD (token abc, valid for 1m0s)".
+func (c CancellationPrice) String() string {
+	if c.Token == "" {
+		return c.Money.String()
+	}
+	return fmt.Sprintf("%s (token %s, valid for %s)", c.Money, c.Token, c.TokenDuration)
+}
+
 func (r *RideDetail) UnmarshalJSON(p []byte) error {
 	var aux rideDetail
-	if err := json.Unmarshal(p, &aux); err != nil {
+	if err := JSONUnmarshal(p, &aux); err != nil {
 		return err
 	}
 	return aux.convert(r)
 }
 
+// Bounds returns the south-west and north-east corners of the smallest box
+// that contains the ride's origin, pickup, destination, dropoff, and vehicle
+// locations. Locations whose latitude and longitude are both zero are treated
+// as unset and are skipped. ok is false if none of the locations are set.
+// The Address fields of the returned locations are empty.
+func (r *RideDetail) Bounds() (sw, ne Location, ok bool) {
+	points := [...][2]float64{
+		{r.Origin.Latitude, r.Origin.Longitude},
+		{r.Pickup.Latitude, r.Pickup.Longitude},
+		{r.Destination.Latitude, r.Destination.Longitude},
+		{r.Dropoff.Latitude, r.Dropoff.Longitude},
+		{r.Location.Latitude, r.Location.Longitude},
+	}
+	for _, p := range points {
+		lat, lng := p[0], p[1]
+		if lat == 0 && lng == 0 {
+			continue
+		}
+		if !ok {
+			sw = Location{Latitude: lat, Longitude: lng}
+			ne = sw
+			ok = true
+			continue
+		}
+		sw.Latitude = math.Min(sw.Latitude, lat)
+		sw.Longitude = math.Min(sw.Longitude, lng)
+		ne.Latitude = math.Max(ne.Latitude, lat)
+		ne.Longitude = math.Max(ne.Longitude, lng)
+	}
+	return sw, ne, ok
+}
+
+// ErrRangeTooOld is returned by RideHistory if Lyft rejects the start time,
+// typically because it is earlier than Lyft retains ride history for.
+// Retry with a later start time.
+var ErrRangeTooOld = errors.New("ride history start time too old")
+
+// hasErrorDetail reports whether any of the error details is for the field.
+func hasErrorDetail(details []map[string]string, field string) bool {
+	for _, d := range details {
+		if _, ok := d[field]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// maxRideHistoryLimit is the maximum ride history limit documented in the
+// Lyft API reference.
+const maxRideHistoryLimit = 50
+
 // RideHistory returns the authenticated user's current and past rides.
 // See the Lyft API reference for details on how far back the
 // start and end times can go. If end is the zero time it is ignored.
-// Limit specifies the maximum number of rides to return. If limit is -1,
-// RideHistory requests the maximum limit documented in the API reference (50).
+// Limit specifies the maximum number of rides to return. If limit is -1 or
+// greater than the maximum limit documented in the API reference (50),
+// RideHistory requests the maximum limit. It is an error for limit to be 0
+// or less than -1.
+//
+// The start and end times may be in any location; they are converted to UTC
+// for the request. The times in the returned rides are not converted; they
+// retain the offset used by Lyft in the response.
 //
 // Implementation detail: The times, in UTC, are formatted using "2006-01-02T15:04:05Z".
 // For example: start.UTC().Format("2006-01-02T15:04:05Z").
+//
+// If Lyft rejects the start time, the error is ErrRangeTooOld. An empty
+// result with a nil error means that there are no rides in the range.
 func (c *Client) RideHistory(start, end time.Time, limit int32) ([]RideDetail, http.Header, error) {
+	return c.RideHistoryContext(context.Background(), start, end, limit)
+}
+
+// RideHistoryContext is like RideHistory, but the request is made with the
+// supplied context. If the context is done, the error wraps the context's
+// error.
+func (c *Client) RideHistoryContext(ctx context.Context, start, end time.Time, limit int32) ([]RideDetail, http.Header, error) {
+	rides, h, err := c.rideHistory(ctx, start, end, limit)
+	return rides, h, contextError(ctx, err)
+}
+
+func (c *Client) rideHistory(ctx context.Context, start, end time.Time, limit int32) ([]RideDetail, http.Header, error) {
 	const layout = "2006-01-02T15:04:05Z"
 
+	if limit == 0 || limit < -1 {
+		return nil, nil, fmt.Errorf("invalid ride history limit %d", limit)
+	}
+	if limit == -1 || limit > maxRideHistoryLimit {
+		limit = maxRideHistoryLimit
+	}
+
 	vals := make(url.Values)
 	vals.Set("start_time", start.UTC().Format(layout))
-	if !end.UTC().IsZero() {
+	if !end.IsZero() {
 		vals.Set("end_time", end.UTC().Format(layout))
 	}
-	if limit == -1 {
-		limit = 50 // max limit documented in the Lyft API reference
-	}
 	vals.Set("limit", strconv.FormatInt(int64(limit), 10))
-	r, err := http.NewRequest("GET", c.base()+"/v1/rides?"+vals.Encode(), nil)
-	if err != nil {
-		return nil, nil, err
-	}
-
-	rsp, err := c.do(r)
-	if err != nil {
-		return nil, nil, err
-	}
-	defer rsp.Body.Close()
-
-	if rsp.StatusCode != 200 {
-		return nil, rsp.Header, NewStatusError(rsp)
-	}
-
 	var response struct {
 		R []RideDetail `json:"ride_history"`
 	}
-	if err := unmarshal(rsp.Body, &response); err != nil {
-		return nil, rsp.Header, err
+	h, err := c.send(ctx, request{
+		method: "GET",
+		path:   "/v1/rides",
+		query:  vals,
+		errorFunc: func(rsp *http.Response) error {
+			serr := NewStatusError(rsp)
+			if rsp.StatusCode == 400 && hasErrorDetail(serr.Details, "start_time") {
+				return ErrRangeTooOld
+			}
+			return serr
+		},
+	}, &response)
+	if err != nil {
+		return nil, h, err
+	}
+	for i := range response.R {
+		response.R[i].Sandbox = c.Sandbox
 	}
-	return response.R, rsp.Header, nil
+	return response.R, h, nil
 }
 
 // UserProfile is returned by the client's UserProfile method.
@@ -311,28 +636,156 @@ type UserProfile struct {
 	FirstName string `json:"first_name"`
 	LastName  string `json:"last_name"`
 	Ridden    bool   `json:"has_taken_a_ride"` // Whether the user has taken at least one ride.
+	// The user's Lyft region (market), if included in the response. As of
+	// 2017-11-05, the region is not documented in the API reference, so it
+	// is usually empty; see UserRegion.
+	Region string `json:"region"`
+	// The following fields are not documented in the API reference. They
+	// are set only if the response includes them, which may depend on the
+	// token's scopes.
+	Email     string `json:"email"`
+	Phone     string `json:"phone_number"`
+	RideCount int    `json:"ride_count"` // Number of rides taken; see also Ridden.
 }
 
-// UserProfile returns the authenticated user's profile info.
+// UserProfile returns the authenticated user's profile info. Lyft's API
+// doesn't support looking up the profiles of other users; use a Client
+// with another user's access token to get that user's profile.
 func (c *Client) UserProfile() (UserProfile, http.Header, error) {
-	r, err := http.NewRequest("GET", c.base()+"/v1/profile", nil)
+	return c.UserProfileContext(context.Background())
+}
+
+// UserProfileContext is like UserProfile, but the request is made with the
+// supplied context. If the context is done, the error wraps the context's
+// error.
+func (c *Client) UserProfileContext(ctx context.Context) (UserProfile, http.Header, error) {
+	var p UserProfile
+	h, err := c.getJSON(ctx, "/v1/profile", nil, &p)
 	if err != nil {
-		return UserProfile{}, nil, err
+		return UserProfile{}, h, contextError(ctx, err)
+	}
+	return p, h, nil
+}
+
+// RegionLookup returns the region for a location, for example using a
+// reverse geocoding service. It is used by UserRegion.
+type RegionLookup func(ctx context.Context, loc Location) (string, error)
+
+// regionRideWindow is how far back UserRegion looks for the most recent ride.
+const regionRideWindow = 365 * 24 * time.Hour
+
+// UserRegion returns the authenticated user's region. If the user's profile
+// includes the region, it is returned. Otherwise the region is derived by
+// calling lookup with the origin of the user's most recent ride in the
+// past year. The region is empty if the profile doesn't include it and
+// either lookup is nil or the user hasn't taken a ride in the past year.
+func (c *Client) UserRegion(ctx context.Context, lookup RegionLookup) (string, error) {
+	var p UserProfile
+	if _, err := c.getJSON(ctx, "/v1/profile", nil, &p); err != nil {
+		return "", contextError(ctx, err)
+	}
+	if p.Region != "" || lookup == nil {
+		return p.Region, nil
 	}
 
-	rsp, err := c.do(r)
+	rides, _, err := c.rideHistory(ctx, time.Now().Add(-regionRideWindow), time.Time{}, -1)
 	if err != nil {
-		return UserProfile{}, nil, err
+		return "", contextError(ctx, err)
+	}
+	var latest *RideDetail
+	for i := range rides {
+		if latest == nil || rides[i].Requested.After(latest.Requested) {
+			latest = &rides[i]
+		}
+	}
+	if latest == nil {
+		return "", nil
 	}
-	defer rsp.Body.Close()
+	return lookup(ctx, Location{
+		Latitude:  latest.Origin.Latitude,
+		Longitude: latest.Origin.Longitude,
+		Address:   latest.Origin.Address,
+	})
+}
+
+// RideSummary is returned by SummarizeRides.
+type RideSummary struct {
+	Count     int
+	Distance  float64 // In miles.
+	Duration  time.Duration
+	Spend     Money
+	RideTypes map[string]RideSummary // Summary per ride type. Nil in the per ride type summaries.
+}
 
-	if rsp.StatusCode != 200 {
-		return UserProfile{}, rsp.Header, NewStatusError(rsp)
+// SummarizeRides totals the count, distance, duration, and price
+// of the rides, such as the rides returned by RideHistory.
+// The error is ErrMixedCurrencies if the prices of the rides are not all
+// in the same currency.
+func SummarizeRides(rides []RideDetail) (RideSummary, error) {
+	s := RideSummary{RideTypes: make(map[string]RideSummary)}
+	for _, r := range rides {
+		if err := s.add(r); err != nil {
+			return RideSummary{}, err
+		}
+		// Cannot fail if adding to the overall summary succeeded.
+		t := s.RideTypes[r.RideType]
+		t.add(r)
+		s.RideTypes[r.RideType] = t
 	}
+	return s, nil
+}
 
-	var p UserProfile
-	if err := unmarshal(rsp.Body, &p); err != nil {
-		return UserProfile{}, rsp.Header, err
+func (s *RideSummary) add(r RideDetail) error {
+	if err := s.Spend.add(r.Price.Amount, r.Price.Currency); err != nil {
+		return err
+	}
+	s.Count++
+	s.Distance += r.Distance
+	s.Duration += r.Duration
+	return nil
+}
+
+// preferredRideWindow is how far back PreferredRideType looks for rides.
+const preferredRideWindow = 90 * 24 * time.Hour
+
+// PreferredRideType returns the authenticated user's most frequently used ride type
+// among the rides requested in the past 90 days. Lyft's API does not expose
+// ride preferences, so this is derived from the ride history; all the rides in
+// the time range are requested, as in RideHistoryAll. Ties are broken in
+// favor of the ride type used most recently. The returned ride type is empty if the
+// user has no rides in the time range. If the context is done, the error wraps
+// the context's error.
+func (c *Client) PreferredRideType(ctx context.Context) (string, error) {
+	rides, err := c.RideHistoryAll(ctx, time.Now().Add(-preferredRideWindow), time.Time{})
+	if err != nil {
+		return "", contextError(ctx, err)
+	}
+	return preferredRideType(rides), nil
+}
+
+func preferredRideType(rides []RideDetail) string {
+	counts := make(map[string]int)
+	latest := make(map[string]time.Time)
+	for _, r := range rides {
+		if r.RideType == "" {
+			continue
+		}
+		counts[r.RideType]++
+		if r.Requested.After(latest[r.RideType]) {
+			latest[r.RideType] = r.Requested
+		}
+	}
+
+	var best string
+	for t, n := range counts {
+		switch {
+		case best == "", n > counts[best]:
+			best = t
+		case n == counts[best] && latest[t].After(latest[best]):
+			best = t
+		case n == counts[best] && latest[t].Equal(latest[best]) && t < best:
+			best = t // for determinism
+		}
 	}
-	return p, rsp.Header, nil
+	return best
 }
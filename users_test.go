@@ -0,0 +1,635 @@
+package lyft
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPreferredRideType(t *testing.T) {
+	day := time.Now().Add(-24 * time.Hour).UTC()
+	rides := []string{}
+	for i, rt := range []string{RideTypeLyft, RideTypePlus, RideTypeLyft, RideTypeLine, RideTypeLyft} {
+		rides = append(rides, fmt.Sprintf(`{"ride_id": "%d", "ride_type": %q, "status": "droppedOff", "requested_at": %q}`,
+			i, rt, day.Add(time.Duration(i)*time.Minute).Format(time.RFC3339)))
+	}
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		start, err := time.Parse(time.RFC3339, r.URL.Query().Get("start_time"))
+		if err != nil || time.Since(start) < 89*24*time.Hour {
+			t.Errorf("start_time = %q, want about 90 days ago", r.URL.Query().Get("start_time"))
+		}
+		fmt.Fprintf(w, `{"ride_history": [%s]}`, strings.Join(rides, ","))
+	})
+
+	got, err := c.PreferredRideType(bg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != RideTypeLyft {
+		t.Errorf("PreferredRideType = %q, want %q", got, RideTypeLyft)
+	}
+}
+
+func TestPreferredRideTypeTie(t *testing.T) {
+	now := time.Now()
+	rides := []RideDetail{
+		{RideType: RideTypeLyft, Requested: now.Add(-2 * time.Hour)},
+		{RideType: RideTypePlus, Requested: now.Add(-time.Hour)},
+		{RideType: ""},
+	}
+	if got := preferredRideType(rides); got != RideTypePlus {
+		t.Errorf("preferredRideType = %q, want the most recent, %q", got, RideTypePlus)
+	}
+	if got := preferredRideType(nil); got != "" {
+		t.Errorf("preferredRideType(nil) = %q, want empty", got)
+	}
+}
+
+func TestRideHistoryLimit(t *testing.T) {
+	tests := []struct {
+		limit int32
+		want  string // sent limit; empty if no request is made
+	}{
+		{100, "50"},
+		{50, "50"},
+		{10, "10"},
+		{-1, "50"},
+		{0, ""},
+		{-2, ""},
+	}
+	for _, tt := range tests {
+		var sent string
+		c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			sent = r.URL.Query().Get("limit")
+			w.Write([]byte(`{"ride_history": []}`))
+		})
+		_, _, err := c.RideHistory(time.Now().Add(-time.Hour), time.Time{}, tt.limit)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("limit %d: expected error", tt.limit)
+			}
+			if sent != "" {
+				t.Errorf("limit %d: request was made", tt.limit)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("limit %d: %v", tt.limit, err)
+		} else if sent != tt.want {
+			t.Errorf("limit %d: sent limit = %q, want %q", tt.limit, sent, tt.want)
+		}
+	}
+}
+
+func TestSummarizeRides(t *testing.T) {
+	usd := func(amount int) Price { return Price{Money: Money{amount, "USD"}, Valid: true} }
+	rides := []RideDetail{
+		{RideType: RideTypeLyft, Distance: 2.5, Duration: 10 * time.Minute, Price: usd(1200)},
+		{RideType: RideTypePlus, Distance: 4, Duration: 20 * time.Minute, Price: usd(2500)},
+		{RideType: RideTypeLyft, Distance: 1.5, Duration: 5 * time.Minute, Price: usd(800)},
+	}
+	s, err := SummarizeRides(rides)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Count != 3 || s.Distance != 8 || s.Duration != 35*time.Minute || s.Spend != (Money{4500, "USD"}) {
+		t.Errorf("summary = %+v", s)
+	}
+	want := map[string]RideSummary{
+		RideTypeLyft: {Count: 2, Distance: 4, Duration: 15 * time.Minute, Spend: Money{2000, "USD"}},
+		RideTypePlus: {Count: 1, Distance: 4, Duration: 20 * time.Minute, Spend: Money{2500, "USD"}},
+	}
+	if len(s.RideTypes) != len(want) {
+		t.Errorf("len(RideTypes) = %d, want %d", len(s.RideTypes), len(want))
+	}
+	for rt, w := range want {
+		got, ok := s.RideTypes[rt]
+		if !ok {
+			t.Errorf("no summary for %s", rt)
+			continue
+		}
+		if got.Count != w.Count || got.Distance != w.Distance || got.Duration != w.Duration || got.Spend != w.Spend {
+			t.Errorf("%s: summary = %+v, want %+v", rt, got, w)
+		}
+		if got.RideTypes != nil {
+			t.Errorf("%s: RideTypes = %v, want nil", rt, got.RideTypes)
+		}
+	}
+
+	rides = append(rides, RideDetail{RideType: RideTypeLyft, Price: Price{Money: Money{900, "CAD"}, Valid: true}})
+	if _, err := SummarizeRides(rides); err != ErrMixedCurrencies {
+		t.Errorf("mixed currencies: err = %v, want ErrMixedCurrencies", err)
+	}
+}
+
+func TestCancelRoleDecode(t *testing.T) {
+	tests := []struct {
+		canceledBy string
+		want       CancelRole
+	}{
+		{"driver", RoleDriver},
+		{"passenger", RolePassenger},
+		{"system", RoleSystem},
+		{"dispatcher", CancelRole("dispatcher")},
+	}
+	wantCanCancel := []CancelRole{RoleDriver, RolePassenger, RoleSystem, "dispatcher"}
+	for _, tt := range tests {
+		body := `{"ride_id": "1", "status": "canceled", "can_cancel": ["driver", "passenger", "system", "dispatcher"],
+			"canceled_by": "` + tt.canceledBy + `"}`
+		var r RideDetail
+		if err := json.Unmarshal([]byte(body), &r); err != nil {
+			t.Errorf("%s: %v", tt.canceledBy, err)
+			continue
+		}
+		if r.CanceledBy != tt.want {
+			t.Errorf("CanceledBy = %q, want %q", r.CanceledBy, tt.want)
+		}
+		if !reflect.DeepEqual(r.CanCancel, wantCanCancel) {
+			t.Errorf("CanCancel = %q, want %q", r.CanCancel, wantCanCancel)
+		}
+	}
+}
+
+func TestRideHistoryUTC(t *testing.T) {
+	loc := time.FixedZone("PDT", -7*60*60)
+	start := time.Date(2026, 6, 1, 17, 30, 0, 0, loc)
+	end := time.Date(2026, 6, 2, 9, 0, 0, 0, loc)
+	var query url.Values
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		w.Write([]byte(`{"ride_history": []}`))
+	})
+	if _, _, err := c.RideHistory(start, end, 10); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := query.Get("start_time"), "2026-06-02T00:30:00Z"; got != want {
+		t.Errorf("start_time = %q, want %q", got, want)
+	}
+	if got, want := query.Get("end_time"), "2026-06-02T16:00:00Z"; got != want {
+		t.Errorf("end_time = %q, want %q", got, want)
+	}
+}
+
+func TestRideDetailBounds(t *testing.T) {
+	// Only the origin, the destination, and the vehicle location are set;
+	// the pickup and dropoff are zero.
+	r := RideDetail{
+		Origin:      RideLocation{Latitude: 37.77, Longitude: -122.41, Address: "1 Market St"},
+		Destination: RideLocation{Latitude: 37.79, Longitude: -122.45},
+		Location:    VehicleLocation{Latitude: 37.76, Longitude: -122.40},
+	}
+	sw, ne, ok := r.Bounds()
+	if !ok {
+		t.Fatal("ok = false, want true")
+	}
+	if want := (Location{Latitude: 37.76, Longitude: -122.45}); sw != want {
+		t.Errorf("sw = %+v, want %+v", sw, want)
+	}
+	if want := (Location{Latitude: 37.79, Longitude: -122.40}); ne != want {
+		t.Errorf("ne = %+v, want %+v", ne, want)
+	}
+
+	// A location with one zero coordinate is still set.
+	r = RideDetail{Dropoff: RideLocation{Latitude: 0, Longitude: 32.5}}
+	sw, ne, ok = r.Bounds()
+	if want := (Location{Longitude: 32.5}); !ok || sw != want || ne != want {
+		t.Errorf("one zero coordinate: Bounds = %+v, %+v, %v, want %+v, %+v, true", sw, ne, ok, want, want)
+	}
+
+	if _, _, ok := (&RideDetail{}).Bounds(); ok {
+		t.Error("no locations: ok = true, want false")
+	}
+}
+
+func TestRideDetailRoute(t *testing.T) {
+	const embedded = `{"ride_id": "1", "route_url": "https://www.lyft.com/routes/1",
+		"route": [{"lat": 37.77, "lng": -122.41}, {"lat": 37.78, "lng": -122.40}, {"lat": 37.79, "lng": -122.39}]}`
+	var r RideDetail
+	if err := json.Unmarshal([]byte(embedded), &r); err != nil {
+		t.Fatal(err)
+	}
+	want := []Location{{Latitude: 37.77, Longitude: -122.41}, {Latitude: 37.78, Longitude: -122.40}, {Latitude: 37.79, Longitude: -122.39}}
+	if !reflect.DeepEqual(r.Route, want) {
+		t.Errorf("Route = %+v, want %+v", r.Route, want)
+	}
+	if r.RouteURL != "https://www.lyft.com/routes/1" {
+		t.Errorf("RouteURL = %q", r.RouteURL)
+	}
+
+	r = RideDetail{}
+	if err := json.Unmarshal([]byte(`{"ride_id": "1", "route_url": "https://www.lyft.com/routes/1"}`), &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Route != nil {
+		t.Errorf("no embedded route: Route = %+v, want nil", r.Route)
+	}
+	if r.RouteURL != "https://www.lyft.com/routes/1" {
+		t.Errorf("no embedded route: RouteURL = %q", r.RouteURL)
+	}
+}
+
+func TestHasBeacon(t *testing.T) {
+	tests := []struct {
+		body  string
+		has   bool
+		color string
+	}{
+		{`{"ride_id": "1", "beacon_string": "#FF00BF"}`, true, "#FF00BF"},
+		{`{"ride_id": "1", "beacon_string": ""}`, true, ""},
+		{`{"ride_id": "1"}`, false, ""},
+		{`{"ride_id": "1", "beacon_string": null}`, false, ""},
+	}
+	for _, tt := range tests {
+		var r RideDetail
+		if err := json.Unmarshal([]byte(tt.body), &r); err != nil {
+			t.Errorf("%s: %v", tt.body, err)
+			continue
+		}
+		if r.HasBeacon() != tt.has || r.BeaconColor != tt.color {
+			t.Errorf("%s: HasBeacon = %v, BeaconColor = %q, want %v, %q", tt.body, r.HasBeacon(), r.BeaconColor, tt.has, tt.color)
+		}
+	}
+}
+
+func TestNormalizedPhone(t *testing.T) {
+	tests := []struct {
+		phone  string
+		number string
+		ext    string
+	}{
+		{"(415) 555-0100", "+14155550100", ""},
+		{"1-415-555-0100", "+14155550100", ""},
+		{"+1 415.555.0100", "+14155550100", ""},
+		{"+44 20 7946 0958", "+442079460958", ""},
+		{"+81 3-1234-5678", "+81312345678", ""},
+		// Relay numbers with extensions.
+		{"+1 415 555 0100 ext. 12345", "+14155550100", "12345"},
+		{"+14155550100;ext=678", "+14155550100", "678"},
+		{"415-555-0100 x 9", "+14155550100", "9"},
+		{"+14155550100,,4321", "+14155550100", "4321"},
+		{"+14155550100#42", "+14155550100", "42"},
+		// Not normalized.
+		{"", "", ""},
+		{"555-0100", "555-0100", ""},
+		{"+1 415 555 0100 x", "+1 415 555 0100 x", ""},
+		{"+1 415 555 0100, mobile", "+1 415 555 0100, mobile", ""},
+		{"fax +1 415 555 0100", "fax +1 415 555 0100", ""},
+		{"Call 415-555-0100", "Call 415-555-0100", ""},
+	}
+	for _, tt := range tests {
+		number, ext := Person{Phone: tt.phone}.NormalizedPhone()
+		if number != tt.number || ext != tt.ext {
+			t.Errorf("NormalizedPhone(%q) = %q, %q, want %q, %q", tt.phone, number, ext, tt.number, tt.ext)
+		}
+	}
+}
+
+func TestSplitPhoneExtension(t *testing.T) {
+	tests := []struct {
+		s, number, ext string
+	}{
+		{"+14155550100x12", "+14155550100", "12"},
+		{"+14155550100 , 12", "+14155550100 ", "12"},
+		// A marker that isn't followed by only digits isn't an extension.
+		{"+14155550100 xyz", "+14155550100 xyz", ""},
+		{"+14155550100, 12 (desk)", "+14155550100, 12 (desk)", ""},
+		{"+14155550100,", "+14155550100,", ""},
+		// A marker at the start isn't an extension.
+		{"x12", "x12", ""},
+	}
+	for _, tt := range tests {
+		number, ext := splitPhoneExtension(tt.s)
+		if number != tt.number || ext != tt.ext {
+			t.Errorf("splitPhoneExtension(%q) = %q, %q, want %q, %q", tt.s, number, ext, tt.number, tt.ext)
+		}
+	}
+}
+
+func TestPickupCountdown(t *testing.T) {
+	now := time.Date(2026, 6, 1, 17, 25, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		body string
+		d    time.Duration
+		ok   bool
+	}{
+		{"eta", `{"ride_id": "1", "origin": {"lat": 37.77, "lng": -122.41, "eta_seconds": 180}}`, 3 * time.Minute, true},
+		{"pickup time", `{"ride_id": "1", "pickup": {"lat": 37.77, "lng": -122.41, "time": "2026-06-01T17:30:00Z"},
+			"origin": {"lat": 37.77, "lng": -122.41, "eta_seconds": 180}}`, 5 * time.Minute, true},
+		{"past pickup time", `{"ride_id": "1", "pickup": {"lat": 37.77, "lng": -122.41, "time": "2026-06-01T17:20:00Z"}}`, 0, true},
+		{"neither", `{"ride_id": "1", "origin": {"lat": 37.77, "lng": -122.41}}`, 0, false},
+	}
+	for _, tt := range tests {
+		var r RideDetail
+		if err := json.Unmarshal([]byte(tt.body), &r); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if d, ok := r.PickupCountdown(now); d != tt.d || ok != tt.ok {
+			t.Errorf("%s: PickupCountdown = %v, %v, want %v, %v", tt.name, d, ok, tt.d, tt.ok)
+		}
+	}
+}
+
+func TestPriceValid(t *testing.T) {
+	tests := []struct {
+		body string
+		want Price
+	}{
+		{`{"amount": 0, "currency": "USD", "description": "Free ride"}`, Price{Money: Money{0, "USD"}, Description: "Free ride", Valid: true}},
+		{`{"amount": 1250, "currency": "USD"}`, Price{Money: Money{1250, "USD"}, Valid: true}},
+		{`{"currency": "USD", "description": "Pending"}`, Price{Money: Money{0, "USD"}, Description: "Pending"}},
+		{`{}`, Price{}},
+	}
+	for _, tt := range tests {
+		var p Price
+		if err := json.Unmarshal([]byte(tt.body), &p); err != nil {
+			t.Errorf("%s: %v", tt.body, err)
+			continue
+		}
+		if !reflect.DeepEqual(p, tt.want) {
+			t.Errorf("%s: Price = %+v, want %+v", tt.body, p, tt.want)
+		}
+	}
+}
+
+func TestUserRegion(t *testing.T) {
+	const history = `{"ride_history": [
+		{"ride_id": "1", "requested_at": "2026-06-01T17:25:00Z", "origin": {"lat": 37.77, "lng": -122.41, "address": "1 Market St"}},
+		{"ride_id": "2", "requested_at": "2026-06-03T09:00:00Z", "origin": {"lat": 40.75, "lng": -73.99, "address": "Penn Station"}},
+		{"ride_id": "3", "requested_at": "2026-06-02T12:00:00Z", "origin": {"lat": 37.79, "lng": -122.39}}
+	]}`
+	newClient := func(profile, rides string) (*Client, *[]string) {
+		var paths []string
+		c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			paths = append(paths, r.URL.Path)
+			if r.URL.Path == "/v1/profile" {
+				w.Write([]byte(profile))
+				return
+			}
+			w.Write([]byte(rides))
+		})
+		return c, &paths
+	}
+	var lookups []Location
+	lookup := func(ctx context.Context, loc Location) (string, error) {
+		lookups = append(lookups, loc)
+		return "NYC", nil
+	}
+
+	c, paths := newClient(`{"id": "u1", "region": "SFO"}`, history)
+	if region, err := c.UserRegion(bg, lookup); err != nil || region != "SFO" {
+		t.Errorf("profile region: UserRegion = %q, %v, want SFO", region, err)
+	}
+	if len(*paths) != 1 || len(lookups) != 0 {
+		t.Errorf("profile region: requests = %q, lookups = %v, want only the profile", *paths, lookups)
+	}
+
+	c, paths = newClient(`{"id": "u1"}`, history)
+	if region, err := c.UserRegion(bg, lookup); err != nil || region != "NYC" {
+		t.Errorf("lookup: UserRegion = %q, %v, want NYC", region, err)
+	}
+	if want := []Location{{Latitude: 40.75, Longitude: -73.99, Address: "Penn Station"}}; !reflect.DeepEqual(lookups, want) {
+		t.Errorf("lookups = %+v, want the most recent origin, %+v", lookups, want)
+	}
+	if want := []string{"/v1/profile", "/v1/rides"}; !reflect.DeepEqual(*paths, want) {
+		t.Errorf("lookup: requests = %q, want %q", *paths, want)
+	}
+
+	lookups = nil
+	c, _ = newClient(`{"id": "u1"}`, `{"ride_history": []}`)
+	if region, err := c.UserRegion(bg, lookup); err != nil || region != "" || len(lookups) != 0 {
+		t.Errorf("no rides: UserRegion = %q, %v, lookups = %v, want empty region and no lookups", region, err, lookups)
+	}
+	c, paths = newClient(`{"id": "u1"}`, history)
+	if region, err := c.UserRegion(bg, nil); err != nil || region != "" || len(*paths) != 1 {
+		t.Errorf("nil lookup: UserRegion = %q, %v, requests = %q", region, err, *paths)
+	}
+}
+
+func TestRideDetailExpense(t *testing.T) {
+	tests := []struct {
+		body       string
+		profile    string
+		code, note string
+	}{
+		{`{"ride_id": "1", "ride_profile": "business", "expense_code": "CLIENT-42", "expense_note": "Client dinner"}`,
+			ProfileBusiness, "CLIENT-42", "Client dinner"},
+		{`{"ride_id": "1", "ride_profile": "personal"}`, ProfilePersonal, "", ""},
+	}
+	for _, tt := range tests {
+		var r RideDetail
+		if err := json.Unmarshal([]byte(tt.body), &r); err != nil {
+			t.Errorf("%s: %v", tt.body, err)
+			continue
+		}
+		if r.RideProfile != tt.profile || r.ExpenseCode != tt.code || r.ExpenseNote != tt.note {
+			t.Errorf("%s: RideProfile, ExpenseCode, ExpenseNote = %q, %q, %q, want %q, %q, %q",
+				tt.body, r.RideProfile, r.ExpenseCode, r.ExpenseNote, tt.profile, tt.code, tt.note)
+		}
+	}
+}
+
+func TestWaitTime(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		d    time.Duration
+		ok   bool
+	}{
+		{"complete", `{"ride_id": "1", "requested_at": "2026-06-01T17:25:00Z",
+			"pickup": {"lat": 37.77, "lng": -122.41, "time": "2026-06-01T17:32:30Z"}}`, 7*time.Minute + 30*time.Second, true},
+		{"not picked up", `{"ride_id": "1", "requested_at": "2026-06-01T17:25:00Z"}`, 0, false},
+		{"no request time", `{"ride_id": "1", "pickup": {"lat": 37.77, "lng": -122.41, "time": "2026-06-01T17:32:30Z"}}`, 0, false},
+	}
+	for _, tt := range tests {
+		var r RideDetail
+		if err := json.Unmarshal([]byte(tt.body), &r); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if d, ok := r.WaitTime(); d != tt.d || ok != tt.ok {
+			t.Errorf("%s: WaitTime = %v, %v, want %v, %v", tt.name, d, ok, tt.d, tt.ok)
+		}
+	}
+}
+
+func TestPriceShapes(t *testing.T) {
+	breakdown := []LineItem{{Money{2000, "USD"}, "Ride"}, {Money{450, "USD"}, "Tip"}}
+	tests := []struct {
+		name string
+		body string
+		want Price
+	}{
+		{"simple", `{"amount": 2450, "currency": "USD", "description": "Lyft fare"}`,
+			Price{Money: Money{2450, "USD"}, Description: "Lyft fare", Valid: true}},
+		{"detailed", `{"total": {"amount": 2450, "currency": "USD", "description": "Lyft fare"},
+			"breakdown": [{"amount": 2000, "currency": "USD", "type": "Ride"}, {"amount": 450, "currency": "USD", "type": "Tip"}]}`,
+			Price{Money: Money{2450, "USD"}, Description: "Lyft fare", Valid: true, Breakdown: breakdown}},
+		{"detailed without amount", `{"total": {"currency": "USD"}, "breakdown": []}`,
+			Price{Money: Money{0, "USD"}, Breakdown: []LineItem{}}},
+		{"both", `{"amount": 2450, "currency": "USD", "total": {"amount": 9999, "currency": "EUR"}}`,
+			Price{Money: Money{2450, "USD"}, Valid: true}},
+	}
+	for _, tt := range tests {
+		var p Price
+		if err := json.Unmarshal([]byte(tt.body), &p); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(p, tt.want) {
+			t.Errorf("%s: Price = %+v, want %+v", tt.name, p, tt.want)
+		}
+	}
+}
+
+func TestUserProfileDecode(t *testing.T) {
+	const body = `{"id": "123456789", "first_name": "Alice", "last_name": "A.", "has_taken_a_ride": true,
+		"email": "alice@example.com", "phone_number": "+14155550100", "ride_count": 42}`
+	var p UserProfile
+	if err := json.Unmarshal([]byte(body), &p); err != nil {
+		t.Fatal(err)
+	}
+	want := UserProfile{ID: "123456789", FirstName: "Alice", LastName: "A.", Ridden: true,
+		Email: "alice@example.com", Phone: "+14155550100", RideCount: 42}
+	if p != want {
+		t.Errorf("UserProfile = %+v, want %+v", p, want)
+	}
+
+	p = UserProfile{}
+	if err := json.Unmarshal([]byte(`{"id": "123456789", "first_name": "Alice", "has_taken_a_ride": false}`), &p); err != nil {
+		t.Fatal(err)
+	}
+	if p.Email != "" || p.Phone != "" || p.RideCount != 0 {
+		t.Errorf("undocumented fields absent: UserProfile = %+v", p)
+	}
+}
+
+func TestRideHistoryRangeTooOld(t *testing.T) {
+	tests := []struct {
+		status int
+		body   string
+		want   error // nil for a *StatusError
+	}{
+		{400, `{"error": "bad_parameter", "error_detail": [{"start_time": "start_time is too far in the past"}]}`, ErrRangeTooOld},
+		{400, `{"error": "bad_parameter", "error_detail": {"start_time": "start_time is too far in the past"}}`, ErrRangeTooOld},
+		{400, `{"error": "bad_parameter", "error_detail": [{"limit": "invalid"}]}`, nil},
+		{500, `{"error": "server_error", "error_detail": [{"start_time": "unavailable"}]}`, nil},
+	}
+	for _, tt := range tests {
+		c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tt.status)
+			w.Write([]byte(tt.body))
+		})
+		_, _, err := c.RideHistory(historyBase, time.Time{}, 10)
+		if tt.want != nil {
+			if err != tt.want {
+				t.Errorf("%s: err = %v, want %v", tt.body, err, tt.want)
+			}
+			continue
+		}
+		if _, ok := err.(*StatusError); !ok {
+			t.Errorf("%s: err = %v (%T), want *StatusError", tt.body, err, err)
+		}
+	}
+}
+
+func TestPersonDecode(t *testing.T) {
+	const body = `{"ride_id": "1",
+		"driver": {"first_name": "Bob", "phone_number": "+14155550100", "rating": 4.9, "rides_count": 1200},
+		"passenger": {"user_id": "u1", "first_name": "Alice", "rating": "5"}}`
+	var r RideDetail
+	if err := json.Unmarshal([]byte(body), &r); err != nil {
+		t.Fatal(err)
+	}
+	wantDriver := Person{FirstName: "Bob", Phone: "+14155550100", Rating: "4.9", RatingValue: 4.9, RidesCount: 1200}
+	if r.Driver != wantDriver {
+		t.Errorf("Driver = %+v, want %+v", r.Driver, wantDriver)
+	}
+	wantPassenger := Person{UserID: "u1", FirstName: "Alice", Rating: "5", RatingValue: 5}
+	if r.Passenger != wantPassenger {
+		t.Errorf("Passenger = %+v, want %+v", r.Passenger, wantPassenger)
+	}
+
+	tests := []struct {
+		body   string
+		rating string
+		value  float64
+	}{
+		{`{"rating": "not rated"}`, "not rated", 0},
+		{`{"rating": null}`, "", 0},
+		{`{}`, "", 0},
+	}
+	for _, tt := range tests {
+		var p Person
+		if err := json.Unmarshal([]byte(tt.body), &p); err != nil {
+			t.Errorf("%s: %v", tt.body, err)
+			continue
+		}
+		if p.Rating != tt.rating || p.RatingValue != tt.value {
+			t.Errorf("%s: Rating, RatingValue = %q, %v, want %q, %v", tt.body, p.Rating, p.RatingValue, tt.rating, tt.value)
+		}
+	}
+	var p Person
+	if err := json.Unmarshal([]byte(`{"rating": true}`), &p); err == nil {
+		t.Error("boolean rating: expected error")
+	}
+}
+
+func TestUnauthorized(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		w.WriteHeader(401)
+		w.Write([]byte(`{"error": "invalid_token", "error_description": "The access token is invalid"}`))
+	})
+	check := func(name string, err error) {
+		t.Helper()
+		serr, ok := err.(*StatusError)
+		if !ok {
+			t.Errorf("%s: err = %v (%T), want *StatusError", name, err, err)
+			return
+		}
+		if serr.StatusCode != 401 || serr.Reason != "invalid_token" || serr.Description != "The access token is invalid" {
+			t.Errorf("%s: StatusError = %+v", name, serr)
+		}
+	}
+	_, h, err := c.RideHistory(historyBase, time.Time{}, 10)
+	check("RideHistory", err)
+	if h == nil {
+		t.Error("RideHistory: header is nil")
+	}
+	_, h, err = c.UserProfileContext(bg)
+	check("UserProfileContext", err)
+	if h == nil {
+		t.Error("UserProfileContext: header is nil")
+	}
+}
+
+func TestUserProfile(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" || r.URL.Path != "/v1/profile" || r.URL.RawQuery != "" {
+			t.Errorf("request = %s %s", r.Method, r.URL)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer token" {
+			t.Errorf("Authorization = %q", got)
+		}
+		w.Write([]byte(`{"id": "123456789", "first_name": "Alice", "last_name": "A.", "has_taken_a_ride": true}`))
+	})
+	p, h, err := c.UserProfile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h == nil {
+		t.Error("header is nil")
+	}
+	want := UserProfile{ID: "123456789", FirstName: "Alice", LastName: "A.", Ridden: true}
+	if p != want {
+		t.Errorf("UserProfile = %+v, want %+v", p, want)
+	}
+}
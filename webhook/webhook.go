@@ -9,9 +9,12 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"hash"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"strings"
 	"time"
 
@@ -24,8 +27,52 @@ const (
 	RideReceiptReady  = "ride.receipt.ready"
 )
 
+// EventTypes returns the event types that Lyft webhooks can be sent for.
+func EventTypes() []string {
+	return []string{RideStatusUpdated, RideReceiptReady}
+}
+
 const SandboxEventPrefix = "sandboxevent"
 
+// Subscription describes a webhook subscription. Lyft's API does not
+// support registering webhooks; they are registered in the Lyft Developer Portal.
+// Subscription is useful for keeping track of, and validating, the
+// subscriptions in an application's configuration.
+type Subscription struct {
+	URL        string   `json:"url"`
+	EventTypes []string `json:"event_types"` // See EventTypes for the supported values.
+}
+
+// Validate checks that the subscription's URL is an absolute https URL,
+// and that its event types are non-empty and are supported event types.
+func (s *Subscription) Validate() error {
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("webhook URL must be an absolute https URL: %q", s.URL)
+	}
+	if len(s.EventTypes) == 0 {
+		return errors.New("no webhook event types")
+	}
+	for _, t := range s.EventTypes {
+		if !supportedEventType(t) {
+			return fmt.Errorf("unsupported webhook event type %q", t)
+		}
+	}
+	return nil
+}
+
+func supportedEventType(t string) bool {
+	for _, e := range EventTypes() {
+		if e == t {
+			return true
+		}
+	}
+	return false
+}
+
 // Event represents an event from a Lyft webhook.
 // It implements json.Unmarshaler in a manner suitable for decoding
 // incoming webhook request bodies.
@@ -47,7 +94,7 @@ func (e *Event) UnmarshalJSON(p []byte) error {
 	type event struct {
 		EventID   string          `json:"event_id"`
 		URL       string          `json:"href"`
-		Occurred  string          `json:"occurred_at"`
+		Occurred  json.RawMessage `json:"occurred_at"`
 		EventType string          `json:"event_type"`
 		Detail    lyft.RideDetail `json:"event"`
 	}
@@ -55,17 +102,63 @@ func (e *Event) UnmarshalJSON(p []byte) error {
 	if err := json.Unmarshal(p, &aux); err != nil {
 		return err
 	}
+	occurred, err := lyft.DecodeTime(aux.Occurred)
+	if err != nil {
+		return err
+	}
 	e.EventID = aux.EventID
 	e.URL = aux.URL
-	if aux.Occurred != "" {
-		o, err := time.Parse(lyft.TimeLayout, aux.Occurred)
-		if err != nil {
-			return err
+	e.Occurred = occurred
+	e.EventType = aux.EventType
+	e.Detail = aux.Detail
+	e.Detail.Sandbox = strings.HasPrefix(aux.EventID, SandboxEventPrefix)
+	return nil
+}
+
+// Errors returned by Freshness.Check.
+var (
+	ErrStale  = errors.New("webhook event is too old")
+	ErrFuture = errors.New("webhook event occurred too far in the future")
+)
+
+// Freshness checks the occurred time of events, which can be used to reject
+// replayed events. Use it after verifying and decoding an event.
+type Freshness struct {
+	// Maximum age of an event. Zero means no maximum.
+	MaxAge time.Duration
+	// Maximum amount that an event's occurred time may be in the future,
+	// to tolerate clock skew between Lyft and the local clock. Zero means
+	// that events in the future are rejected.
+	MaxSkew time.Duration
+	// Optional. Called with a message when an event's occurred time is in
+	// the future, even if the event is accepted, so that clock skew can be
+	// detected. The log.Printf function is suitable.
+	Logf func(format string, args ...interface{})
+}
+
+// Check returns ErrStale if the event is older than MaxAge, or ErrFuture
+// if the event's occurred time is more than MaxSkew after now. An event
+// with no occurred time is treated as stale if MaxAge is set.
+func (f *Freshness) Check(e *Event, now time.Time) error {
+	if e.Occurred.IsZero() {
+		if f.MaxAge > 0 {
+			return ErrStale
 		}
-		e.Occurred = o
+		return nil
+	}
+	age := now.Sub(e.Occurred)
+	if age < 0 {
+		if f.Logf != nil {
+			f.Logf("webhook: event %s occurred %s in the future", e.EventID, -age)
+		}
+		if -age > f.MaxSkew {
+			return ErrFuture
+		}
+		return nil
+	}
+	if f.MaxAge > 0 && age > f.MaxAge {
+		return ErrStale
 	}
-	e.EventID = aux.EventType
-	e.Detail = aux.Detail
 	return nil
 }
 
@@ -86,12 +179,17 @@ func Verify(requestBody, signature, verificationToken []byte) (bool, error) {
 	if err != nil {
 		return false, err
 	}
+	return verifyMAC(mac, signature)
+}
+
+// verifyMAC checks whether the base64 encoded sum of mac equals the signature.
+func verifyMAC(mac hash.Hash, signature []byte) (bool, error) {
 	expectedMAC := mac.Sum(nil)
 
 	// Base64 encode the body MAC.
 	var buf bytes.Buffer
 	enc := base64.NewEncoder(base64.StdEncoding, &buf)
-	_, err = enc.Write(expectedMAC)
+	_, err := enc.Write(expectedMAC)
 	if err != nil {
 		return false, err
 	}
@@ -103,6 +201,41 @@ func Verify(requestBody, signature, verificationToken []byte) (bool, error) {
 	return hmac.Equal(buf.Bytes(), signature), nil
 }
 
+// VerifyingReader verifies the data read from an underlying reader
+// in the manner of Verify, without buffering the data. Use
+// NewVerifyingReader to create a VerifyingReader.
+type VerifyingReader struct {
+	r         io.Reader
+	mac       hash.Hash
+	signature []byte
+}
+
+// NewVerifyingReader returns a reader that reads from r and verifies
+// the data read against the signature. The signature and verification token
+// are as described in Verify. If verification fails, the reader returns
+// ErrVerify instead of io.EOF at the end of the data.
+func NewVerifyingReader(r io.Reader, signature, verificationToken []byte) *VerifyingReader {
+	return &VerifyingReader{
+		r:         r,
+		mac:       hmac.New(sha256.New, verificationToken),
+		signature: signature,
+	}
+}
+
+func (v *VerifyingReader) Read(p []byte) (int, error) {
+	n, err := v.r.Read(p)
+	v.mac.Write(p[:n]) // never returns an error
+	if err == io.EOF {
+		ok, verr := verifyMAC(v.mac, v.signature)
+		if verr != nil {
+			return n, verr
+		} else if !ok {
+			return n, ErrVerify
+		}
+	}
+	return n, err
+}
+
 // ErrVerify is returned by DecodeEvent if the request could not be
 // verified to have been originating from Lyft.
 var ErrVerify = errors.New("failed to verify request")
@@ -132,6 +265,27 @@ func DecodeEvent(requestBody io.ReadCloser, h http.Header, verificationToken []b
 	return e, unmarshal(decodeBuf, &e)
 }
 
+// DecodeEventStream is like DecodeEvent, but it verifies the request body
+// while decoding it instead of reading the entire body into memory first.
+// This is useful for large request bodies. The error will be ErrVerify if
+// verification fails, in which case the decoded event is discarded.
+func DecodeEventStream(requestBody io.ReadCloser, h http.Header, verificationToken []byte) (Event, error) {
+	defer drainAndClose(requestBody)
+	vr := NewVerifyingReader(requestBody, []byte(Signature(h)), verificationToken)
+
+	var e Event
+	decodeErr := json.NewDecoder(vr).Decode(&e)
+
+	// Read the remainder, so that the entire body is verified.
+	if _, err := io.Copy(ioutil.Discard, vr); err != nil {
+		return Event{}, err
+	}
+	if decodeErr != nil {
+		return Event{}, decodeErr
+	}
+	return e, nil
+}
+
 func drainAndClose(r io.ReadCloser) {
 	io.Copy(ioutil.Discard, r)
 	r.Close()
@@ -0,0 +1,218 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/nishanths/lyft-go"
+)
+
+func TestEventUnmarshalOccurred(t *testing.T) {
+	want := time.Date(2017, 11, 5, 17, 4, 51, 0, time.UTC)
+	for _, occurred := range []string{`"2017-11-05T17:04:51Z"`, `1509901491`} {
+		p := `{
+			"event_id": "sandboxevent_123",
+			"href": "https://api.lyft.com/v1/rides/1",
+			"occurred_at": ` + occurred + `,
+			"event_type": "ride.status.updated",
+			"event": {"ride_id": "1", "status": "accepted"}
+		}`
+		var e Event
+		if err := json.Unmarshal([]byte(p), &e); err != nil {
+			t.Fatalf("occurred_at %s: %v", occurred, err)
+		}
+		if !e.Occurred.Equal(want) {
+			t.Errorf("occurred_at %s: Occurred = %v, want %v", occurred, e.Occurred, want)
+		}
+		if e.EventID != "sandboxevent_123" || e.EventType != RideStatusUpdated {
+			t.Errorf("EventID, EventType = %q, %q", e.EventID, e.EventType)
+		}
+		if e.Detail.RideID != "1" || !e.Detail.Sandbox {
+			t.Errorf("Detail = %+v", e.Detail)
+		}
+	}
+}
+
+func TestEventUnmarshalNoOccurred(t *testing.T) {
+	var e Event
+	if err := json.Unmarshal([]byte(`{"event_id": "1", "occurred_at": null}`), &e); err != nil {
+		t.Fatal(err)
+	}
+	if !e.Occurred.IsZero() {
+		t.Errorf("Occurred = %v, want zero", e.Occurred)
+	}
+}
+
+func sign(body, token []byte) string {
+	mac := hmac.New(sha256.New, token)
+	mac.Write(body)
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// closeRecorder records whether it was closed.
+type closeRecorder struct {
+	io.Reader
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
+
+// largeEventBody returns an event body of more than 1 MB.
+func largeEventBody() []byte {
+	return []byte(`{
+		"event_id": "123",
+		"href": "https://api.lyft.com/v1/rides/1",
+		"occurred_at": "2017-11-05T17:04:51Z",
+		"event_type": "ride.status.updated",
+		"event": {"ride_id": "1", "status": "accepted", "padding": "` + strings.Repeat("x", 1<<20) + `"}
+	}`)
+}
+
+func TestDecodeEventStream(t *testing.T) {
+	token := []byte("verification-token")
+	body := largeEventBody()
+	tampered := bytes.Replace(body, []byte(`"accepted"`), []byte(`"canceled"`), 1)
+
+	tests := []struct {
+		name      string
+		body      []byte
+		signature string
+		err       error
+	}{
+		{"valid", body, sign(body, token), nil},
+		{"tampered body", tampered, sign(body, token), ErrVerify},
+		{"tampered signature", body, sign(body, []byte("other-token")), ErrVerify},
+		{"no signature", body, "", ErrVerify},
+	}
+	for _, tt := range tests {
+		h := make(http.Header)
+		if tt.signature != "" {
+			h.Set("X-Lyft-Signature", "sha256="+tt.signature)
+		}
+		rc := &closeRecorder{Reader: iotest.HalfReader(bytes.NewReader(tt.body))}
+		e, err := DecodeEventStream(rc, h, token)
+		if err != tt.err {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.err)
+		}
+		if !rc.closed {
+			t.Errorf("%s: body not closed", tt.name)
+		}
+		if tt.err != nil {
+			if !reflect.DeepEqual(e, Event{}) {
+				t.Errorf("%s: event ID = %q, want the zero Event", tt.name, e.EventID)
+			}
+			continue
+		}
+		if e.EventID != "123" || e.Detail.RideID != "1" || e.Detail.RideStatus != lyft.StatusAccepted {
+			t.Errorf("%s: event ID, ride ID, status = %q, %q, %q", tt.name, e.EventID, e.Detail.RideID, e.Detail.RideStatus)
+		}
+
+		// DecodeEvent agrees with DecodeEventStream.
+		e2, err := DecodeEvent(ioutil.NopCloser(bytes.NewReader(tt.body)), h, token)
+		if err != nil || !reflect.DeepEqual(e, e2) {
+			t.Errorf("%s: DecodeEvent = %v, want the same event", tt.name, err)
+		}
+	}
+}
+
+func TestVerifyingReaderTrailingData(t *testing.T) {
+	// The signature covers data after the JSON value too, so appending
+	// data fails verification even though the event decodes.
+	token := []byte("verification-token")
+	body := largeEventBody()
+	h := http.Header{"X-Lyft-Signature": {"sha256=" + sign(body, token)}}
+	withTrailer := append(append([]byte(nil), body...), "\n{}"...)
+	if _, err := DecodeEventStream(ioutil.NopCloser(bytes.NewReader(withTrailer)), h, token); err != ErrVerify {
+		t.Errorf("err = %v, want ErrVerify", err)
+	}
+}
+
+func TestFreshnessCheck(t *testing.T) {
+	now := time.Date(2017, 11, 5, 17, 0, 0, 0, time.UTC)
+	f := Freshness{MaxAge: 5 * time.Minute, MaxSkew: 30 * time.Second}
+	tests := []struct {
+		name     string
+		occurred time.Time
+		err      error
+		logged   bool
+	}{
+		{"now", now, nil, false},
+		{"recent", now.Add(-time.Minute), nil, false},
+		{"slightly in the future", now.Add(10 * time.Second), nil, true},
+		{"far in the future", now.Add(time.Hour), ErrFuture, true},
+		{"stale", now.Add(-10 * time.Minute), ErrStale, false},
+		{"no occurred time", time.Time{}, ErrStale, false},
+	}
+	for _, tt := range tests {
+		var logs []string
+		f.Logf = func(format string, args ...interface{}) {
+			logs = append(logs, fmt.Sprintf(format, args...))
+		}
+		e := Event{EventID: "123", Occurred: tt.occurred}
+		if err := f.Check(&e, now); err != tt.err {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.err)
+		}
+		if logged := len(logs) != 0; logged != tt.logged {
+			t.Errorf("%s: logs = %q, want logged %v", tt.name, logs, tt.logged)
+		}
+	}
+
+	// Without MaxAge, old events and events without an occurred time are
+	// accepted; without MaxSkew, any event in the future is rejected.
+	var zero Freshness
+	for _, occurred := range []time.Time{{}, now.Add(-24 * time.Hour)} {
+		if err := zero.Check(&Event{Occurred: occurred}, now); err != nil {
+			t.Errorf("zero Freshness, occurred %v: err = %v", occurred, err)
+		}
+	}
+	if err := zero.Check(&Event{Occurred: now.Add(time.Second)}, now); err != ErrFuture {
+		t.Errorf("zero Freshness, future: err = %v, want ErrFuture", err)
+	}
+}
+
+func TestSubscriptionValidate(t *testing.T) {
+	tests := []struct {
+		body string
+		ok   bool
+	}{
+		{`{"url": "https://example.com/lyft", "event_types": ["ride.status.updated", "ride.receipt.ready"]}`, true},
+		{`{"url": "http://example.com/lyft", "event_types": ["ride.status.updated"]}`, false},
+		{`{"url": "/lyft", "event_types": ["ride.status.updated"]}`, false},
+		{`{"url": "https://example.com/lyft", "event_types": []}`, false},
+		{`{"url": "https://example.com/lyft"}`, false},
+		{`{"url": "https://example.com/lyft", "event_types": ["ride.status.updated", "ride.created"]}`, false},
+	}
+	for _, tt := range tests {
+		var s Subscription
+		if err := json.Unmarshal([]byte(tt.body), &s); err != nil {
+			t.Errorf("%s: %v", tt.body, err)
+			continue
+		}
+		if err := s.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: Validate = %v, want ok %v", tt.body, err, tt.ok)
+		}
+	}
+
+	var s Subscription
+	if err := json.Unmarshal([]byte(`{"url": "https://example.com/lyft", "event_types": ["ride.receipt.ready"]}`), &s); err != nil {
+		t.Fatal(err)
+	}
+	if want := (Subscription{URL: "https://example.com/lyft", EventTypes: []string{RideReceiptReady}}); !reflect.DeepEqual(s, want) {
+		t.Errorf("decoded = %+v, want %+v", s, want)
+	}
+}